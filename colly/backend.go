@@ -3,8 +3,61 @@ package colly
 import (
 	"fmt"
 	"errors"
+	"time"
 
 	"github.com/go-redis/redis"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/smileboywtu/FileColly/common"
+)
+
+// ErrQueueFull is returned when the destination refuse the write
+// because of the queue size limit
+var ErrQueueFull = errors.New("destination queue reach the limit size")
+
+// RejectedError is returned when destination refuse the message for good,
+// sending it again will not help
+type RejectedError struct {
+	Status int
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("destination rejected message, status: %d, reason: %s", e.Status, e.Reason)
+}
+
+// IsRejected check if send error is a permanent rejection
+func IsRejected(err error) bool {
+	_, ok := pkgerrors.Cause(err).(*RejectedError)
+	return ok
+}
+
+// RetryAfterError is returned when destination asks how long to wait
+// before the message is sent again
+type RetryAfterError struct {
+	Err  error
+	Wait time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.Wait)
+}
+
+// RetryAfter return the wait asked by destination in send error, 0 if
+// it did not ask
+func RetryAfter(err error) time.Duration {
+	if e, ok := pkgerrors.Cause(err).(*RetryAfterError); ok {
+		return e.Wait
+	}
+	return 0
+}
+
+// destination backend types
+const (
+	BackendList   = "list"
+	BackendStream = "stream"
+	BackendSpool  = "spool"
+	BackendHTTP   = "http"
+	BackendS3     = "s3"
 )
 
 type DestWriter interface {
@@ -13,19 +66,48 @@ type DestWriter interface {
 	SendFileContent(buffer string) error
 }
 
+// FieldWriter is implemented by backend which carries metadata fields
+// beside the message, fields are name, value pairs of Envelope.Fields
+type FieldWriter interface {
+	SendFields(buffer string, fields []string) error
+}
+
 type RedisWriter struct {
-	Client         *redis.Client
-	DestQueueName  string
+	// standalone, failover or cluster client
+	Client        redis.UniversalClient
+	DestQueueName string
+	// max messages in queue, 0 refuses every push, negative for no limit
 	QueueSizeLimit int
+
+	// claim-check mode stores each message in its own key expiring after
+	// ClaimTTL, 0 for never, and pushes only a reference
+	ClaimCheck bool
+	ClaimTTL   time.Duration
+
+	// bytes in queue, or stored in claim-check mode, are capped by MaxBytes.
+	// pushes pause while redis used_memory is over MaxMemory. 0 for no limit
+	MaxBytes  int64
+	MaxMemory int64
+
+	memory memoryGuard
 }
 
 // NewRedisWriter init a new backend for cache and exchange
 func NewRedisWriter(opts *redis.Options, destQName string, qLimit int) (*RedisWriter, error) {
-	client := redis.NewClient(opts)
+	w, err := NewRedisWriterWithClient(redis.NewClient(opts), destQName, qLimit)
+	if err != nil {
+		return nil, fmt.Errorf("%s, connect params: %s", err, opts.Addr)
+	}
+	return w, nil
+}
 
+// NewRedisWriterWithClient init backend on an existing client, the
+// client is closed if it can not connect
+func NewRedisWriterWithClient(client redis.UniversalClient, destQName string, qLimit int) (*RedisWriter, error) {
 	pong, err := client.Ping().Result()
 	if err != nil || pong != "PONG" {
-		return nil, errors.New(fmt.Sprintf("redis connect error: %s, connect params: %s", err, opts))
+		client.Close()
+		return nil, errors.New(fmt.Sprintf("redis connect error: %s", err))
 	}
 
 	return &RedisWriter{
@@ -35,6 +117,88 @@ func NewRedisWriter(opts *redis.Options, destQName string, qLimit int) (*RedisWr
 	}, nil
 }
 
+// CheckBackend check if backend type is known
+func CheckBackend(backend string) error {
+	switch backend {
+	case "", BackendList, BackendStream, BackendSpool, BackendHTTP, BackendS3:
+		return nil
+	}
+	return fmt.Errorf("unknown backend: %s", backend)
+}
+
+// NewBackend create destination writer selected by config, the writer
+// may implement io.Closer
+func NewBackend(opts *AppConfigOption) (DestWriter, error) {
+	if err := CheckBackend(opts.Backend); err != nil {
+		return nil, err
+	}
+	switch opts.Backend {
+	case BackendSpool:
+		w, err := NewSpoolWriter(opts.SpoolDirectory, opts.DestinationRedisQueueLimit)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	case BackendHTTP:
+		w, err := NewHTTPWriter(opts)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	case BackendS3:
+		w, err := NewS3Writer(opts)
+		if err != nil {
+			return nil, err
+		}
+		return w, nil
+	}
+
+	client, err := opts.RedisClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Backend == BackendStream {
+		w, err := NewStreamWriterWithClient(client, opts.DestinationRedisQueueName, opts.DestinationRedisQueueLimit)
+		if err != nil {
+			return nil, err
+		}
+		w.MaxMemory = common.HumanSize2Bytes(opts.RedisMaxMemory)
+		return w, nil
+	}
+	w, err := NewRedisWriterWithClient(client, opts.DestinationRedisQueueName, opts.DestinationRedisQueueLimit)
+	if err != nil {
+		return nil, err
+	}
+	w.ClaimCheck = opts.RedisClaimCheck
+	w.ClaimTTL = opts.ClaimTTL()
+	w.MaxBytes = common.HumanSize2Bytes(opts.DestinationRedisQueueMaxBytes)
+	w.MaxMemory = common.HumanSize2Bytes(opts.RedisMaxMemory)
+	return w, nil
+}
+
+// Lane return writer pushing to another queue on the same client
+func (w *RedisWriter) Lane(queue string, limit int) DestWriter {
+	return w.lane(queue, limit)
+}
+
+func (w *RedisWriter) lane(queue string, limit int) *RedisWriter {
+	return &RedisWriter{
+		Client:         w.Client,
+		DestQueueName:  queue,
+		QueueSizeLimit: limit,
+		ClaimCheck:     w.ClaimCheck,
+		ClaimTTL:       w.ClaimTTL,
+		MaxBytes:       w.MaxBytes,
+		MaxMemory:      w.MaxMemory,
+	}
+}
+
+// Close close redis client
+func (w *RedisWriter) Close() error {
+	return w.Client.Close()
+}
+
 // GetDestQueueSize get current destination queue size
 func (w *RedisWriter) GetDestQueueSize() int64 {
 	clen, err := w.Client.LLen(w.DestQueueName).Result()
@@ -44,15 +208,113 @@ func (w *RedisWriter) GetDestQueueSize() int64 {
 	return clen
 }
 
-// SendFileContent send one file to redis
+// GetDestQueueBytes get bytes of messages in destination queue, or
+// stored in claim-check mode
+func (w *RedisWriter) GetDestQueueBytes() int64 {
+	if w.ClaimCheck {
+		bytes, err := w.Client.Get(QueueBytesKey(w.DestQueueName)).Int64()
+		if err != nil {
+			return 0
+		}
+		return bytes
+	}
+	bytes, err := queueBytesScript.Run(w.Client, w.pushKeys()).Result()
+	if err != nil {
+		return 0
+	}
+	n, _ := bytes.(int64)
+	return n
+}
+
+// pushScript check queue length and bytes and push in one step, so that
+// concurrent senders never overshoot the limits. -1 means queue full.
+// the size of each message is pushed to size list too, sizes of messages
+// popped since last push are counted off first. a message larger than
+// the byte limit still goes into an empty queue
+var pushScript = redis.NewScript(trimSizes + `
+local limit, maxBytes, size = tonumber(ARGV[1]), tonumber(ARGV[2]), string.len(ARGV[3])
+if limit >= 0 and length >= limit then
+	redis.call("SET", KEYS[2], bytes)
+	return -1
+end
+if maxBytes > 0 and length > 0 and bytes + size > maxBytes then
+	redis.call("SET", KEYS[2], bytes)
+	return -1
+end
+redis.call("SET", KEYS[2], bytes + size)
+redis.call("LPUSH", KEYS[3], size)
+return redis.call("LPUSH", KEYS[1], ARGV[3])
+`)
+
+// SendFileContent send one file to redis, nil is returned only
+// when redis acknowledges the push
 func (w *RedisWriter) SendFileContent(buffer string) error {
-	if !w.Check() {
-		return errors.New(fmt.Sprintf("send file to destination error"))
+	if w.memory.Full(w.Client, w.MaxMemory) {
+		return ErrQueueFull
 	}
-	if !w.IsAllow() {
-		return errors.New(fmt.Sprintf("destination queue reach the limit size(%d)", w.QueueSizeLimit))
+	if w.ClaimCheck {
+		keys, args, err := w.claimArgs(buffer)
+		if err != nil {
+			return err
+		}
+		return pushResult(claimScript.Run(w.Client, keys, args...))
+	}
+	return pushResult(pushScript.Run(w.Client, w.pushKeys(), w.QueueSizeLimit, w.MaxBytes, buffer))
+}
+
+// SendBatch push files in one pipeline, each push still checks the
+// queue limit on its own
+func (w *RedisWriter) SendBatch(buffers []string) []error {
+	pipe := w.Client.Pipeline()
+	defer pipe.Close()
+
+	errs := make([]error, len(buffers))
+	if w.memory.Full(w.Client, w.MaxMemory) {
+		for i := range errs {
+			errs[i] = ErrQueueFull
+		}
+		return errs
+	}
+
+	cmds := make([]*redis.Cmd, len(buffers))
+	for i, buffer := range buffers {
+		if !w.ClaimCheck {
+			cmds[i] = pushScript.Eval(pipe, w.pushKeys(), w.QueueSizeLimit, w.MaxBytes, buffer)
+			continue
+		}
+		keys, args, err := w.claimArgs(buffer)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		cmds[i] = claimScript.Eval(pipe, keys, args...)
+	}
+	pipe.Exec()
+
+	for i, cmd := range cmds {
+		if cmd != nil {
+			errs[i] = pushResult(cmd)
+		}
+	}
+	return errs
+}
+
+func (w *RedisWriter) pushKeys() []string {
+	return []string{w.DestQueueName, QueueBytesKey(w.DestQueueName), QueueSizesKey(w.DestQueueName)}
+}
+
+func pushResult(cmd *redis.Cmd) error {
+	result, err := cmd.Result()
+	if err != nil {
+		return err
+	}
+	n, ok := result.(int64)
+	if !ok {
+		return fmt.Errorf("unexpected push result: %v", result)
+	}
+	if n < 0 {
+		return ErrQueueFull
 	}
-	w.Client.LPush(w.DestQueueName, buffer)
 	return nil
 }
 
@@ -67,14 +329,18 @@ func (w *RedisWriter) Check() bool {
 	return true
 }
 
-// IsAllow check if the dest queue size is out of limit size
+// IsAllow check queue length and redis memory before the message is
+// ready, e.g. before s3 upload, pushes check the limits again on their own
 func (w *RedisWriter) IsAllow() bool {
 	currentSize, err := w.Client.LLen(w.DestQueueName).Result()
 	if err != nil {
 		return false
 	}
 
-	if int(currentSize) >= w.QueueSizeLimit {
+	if w.QueueSizeLimit >= 0 && int(currentSize) >= w.QueueSizeLimit {
+		return false
+	}
+	if w.memory.Full(w.Client, w.MaxMemory) {
 		return false
 	}
 
@@ -2,7 +2,9 @@
 package colly
 
 import (
+	"sync"
 	"testing"
+	"sync/atomic"
 	"github.com/go-redis/redis"
 )
 
@@ -24,4 +26,67 @@ func TestNewRedisWriter(t *testing.T) {
 	} else {
 		t.Error("test create new backend inst error")
 	}
-}
\ No newline at end of file
+}
+func TestRedisWriter_QueueLimit(t *testing.T) {
+	queue := DestQueueName + ":limit"
+	inst, errs := NewRedisWriter(opts, queue, 5)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	inst.Client.Del(queue)
+	defer inst.Client.Del(queue)
+
+	var wg sync.WaitGroup
+	var sent, full int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch err := inst.SendFileContent("x"); err {
+			case nil:
+				atomic.AddInt64(&sent, 1)
+			case ErrQueueFull:
+				atomic.AddInt64(&full, 1)
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if sent != 5 || full != 15 || inst.GetDestQueueSize() != 5 {
+		t.Errorf("queue limit overshoot, sent: %d, full: %d, size: %d", sent, full, inst.GetDestQueueSize())
+	}
+	if inst.IsAllow() {
+		t.Error("full queue should not allow")
+	}
+
+	// 0 refuses every push, negative is no limit
+	if closed := inst.lane(queue, 0); closed.IsAllow() || closed.SendFileContent("x") != ErrQueueFull {
+		t.Error("queue limit 0 should refuse")
+	}
+	unlimited := inst.lane(queue, -1)
+	if !unlimited.IsAllow() || unlimited.SendFileContent("x") != nil {
+		t.Error("queue without limit should allow")
+	}
+}
+
+func TestRedisWriter_SendBatch(t *testing.T) {
+	queue := DestQueueName + ":batch"
+	inst, errs := NewRedisWriter(opts, queue, 3)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	inst.Client.Del(queue)
+	defer inst.Client.Del(queue)
+
+	results := inst.SendBatch([]string{"a", "b", "c", "d"})
+	for i, err := range results {
+		if i < 3 && err != nil {
+			t.Errorf("message %d should be pushed: %v", i, err)
+		}
+		if i == 3 && err != ErrQueueFull {
+			t.Errorf("message %d should hit queue limit: %v", i, err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Accumulate messages and push them in batches
+package colly
+
+import (
+	"time"
+)
+
+// BatchWriter is implemented by backend which can push many messages in
+// one round trip, the error of each message is reported in order
+type BatchWriter interface {
+	SendBatch(buffers []string) []error
+}
+
+// FieldBatchWriter is implemented by batch backend which carries
+// metadata fields beside each message, fields may be nil for a message
+// sent without them
+type FieldBatchWriter interface {
+	SendFieldsBatch(buffers []string, fields [][]string) []error
+}
+
+type batchRequest struct {
+	buffer string
+	fields []string
+	result chan error
+}
+
+// Batcher is a DestWriter which groups concurrent sends into batches
+// bounded by count, bytes and linger time. each sender still gets the
+// result of its own message
+type Batcher struct {
+	DestWriter
+
+	MaxCount int
+	MaxBytes int
+	Linger   time.Duration
+
+	backend  BatchWriter
+	requests chan *batchRequest
+	done     chan struct{}
+}
+
+// NewBatcher wrap backend with batching, the backend is returned as it
+// is if it can not push in batch or batch count is less than 2
+func NewBatcher(backend DestWriter, maxCount int, maxBytes int, linger time.Duration) DestWriter {
+	batchBackend, ok := backend.(BatchWriter)
+	if !ok || maxCount < 2 {
+		return backend
+	}
+
+	b := &Batcher{
+		DestWriter: backend,
+		MaxCount:   maxCount,
+		MaxBytes:   maxBytes,
+		Linger:     linger,
+		backend:    batchBackend,
+		requests:   make(chan *batchRequest, maxCount),
+		done:       make(chan struct{}),
+	}
+	go b.run()
+
+	return b
+}
+
+// SendFileContent queue message into next batch and wait for its result
+func (b *Batcher) SendFileContent(buffer string) error {
+	return b.SendFields(buffer, nil)
+}
+
+// SendFields queue message with metadata fields, the fields are dropped
+// if backend does not carry them
+func (b *Batcher) SendFields(buffer string, fields []string) error {
+	req := &batchRequest{buffer: buffer, fields: fields, result: make(chan error, 1)}
+	b.requests <- req
+	return <-req.result
+}
+
+// Close flush pending messages and stop batching, no send is allowed
+// after close
+func (b *Batcher) Close() {
+	close(b.requests)
+	<-b.done
+}
+
+func (b *Batcher) run() {
+	defer close(b.done)
+
+	var carry *batchRequest
+	for {
+		first := carry
+		carry = nil
+		if first == nil {
+			var ok bool
+			if first, ok = <-b.requests; !ok {
+				return
+			}
+		}
+
+		batch := []*batchRequest{first}
+		size := len(first.buffer)
+		timer := time.NewTimer(b.Linger)
+
+	collect:
+		for len(batch) < b.MaxCount {
+			select {
+			case req, ok := <-b.requests:
+				if !ok {
+					break collect
+				}
+				if b.MaxBytes > 0 && size+len(req.buffer) > b.MaxBytes {
+					carry = req
+					break collect
+				}
+				batch = append(batch, req)
+				size += len(req.buffer)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.flush(batch)
+	}
+}
+
+func (b *Batcher) flush(batch []*batchRequest) {
+	buffers := make([]string, len(batch))
+	fields := make([][]string, len(batch))
+	for i, req := range batch {
+		buffers[i], fields[i] = req.buffer, req.fields
+	}
+
+	var errs []error
+	if fb, ok := b.backend.(FieldBatchWriter); ok {
+		errs = fb.SendFieldsBatch(buffers, fields)
+	} else {
+		errs = b.backend.SendBatch(buffers)
+	}
+	for i, req := range batch {
+		req.result <- errs[i]
+	}
+}
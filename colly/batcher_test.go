@@ -0,0 +1,94 @@
+// Test Suit for batched sending
+package colly
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type batchRecorder struct {
+	flakyWriter
+	sync.Mutex
+	batches [][]string
+}
+
+func (w *batchRecorder) SendBatch(buffers []string) []error {
+	w.Lock()
+	w.batches = append(w.batches, buffers)
+	w.Unlock()
+
+	errs := make([]error, len(buffers))
+	for i, buffer := range buffers {
+		if buffer == "bad" {
+			errs[i] = errors.New("rejected")
+		}
+	}
+	return errs
+}
+
+func TestBatcher_SendFileContent(t *testing.T) {
+	w := &batchRecorder{}
+	b := NewBatcher(w, 4, 0, 50*time.Millisecond).(*Batcher)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			buffer := "good"
+			if i == 3 {
+				buffer = "bad"
+			}
+			errs[i] = b.SendFileContent(buffer)
+		}(i)
+	}
+	wg.Wait()
+	b.Close()
+
+	for i, err := range errs {
+		if (err != nil) != (i == 3) {
+			t.Errorf("message %d: unexpected result %v", i, err)
+		}
+	}
+
+	total := 0
+	for _, batch := range w.batches {
+		if len(batch) > 4 {
+			t.Errorf("batch over count limit: %d", len(batch))
+		}
+		total += len(batch)
+	}
+	if total != 10 || len(w.batches) >= 10 {
+		t.Errorf("messages not batched, batches: %d, total: %d", len(w.batches), total)
+	}
+}
+
+func TestBatcher_MaxBytes(t *testing.T) {
+	w := &batchRecorder{}
+	b := NewBatcher(w, 10, 8, 20*time.Millisecond).(*Batcher)
+	defer b.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.SendFileContent("12345")
+		}()
+	}
+	wg.Wait()
+
+	if len(w.batches) != 4 {
+		t.Errorf("each 5 bytes message should be its own batch with 8 bytes limit, got %d batches", len(w.batches))
+	}
+}
+
+func TestNewBatcher_Disabled(t *testing.T) {
+	w := &flakyWriter{}
+	if NewBatcher(w, 10, 0, time.Millisecond) != DestWriter(w) {
+		t.Error("backend without batch support should not be wrapped")
+	}
+}
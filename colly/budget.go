@@ -0,0 +1,78 @@
+// Bound bytes held in memory across all readers
+package colly
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBudget is a weighted semaphore of bytes
+type MemoryBudget struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	// max bytes in flight, no limit if not positive
+	Limit int64
+	used  int64
+	ctx   context.Context
+}
+
+// NewMemoryBudget create budget of limit bytes, waiters are woken up
+// when ctx is done
+func NewMemoryBudget(ctx context.Context, limit int64) *MemoryBudget {
+	b := &MemoryBudget{Limit: limit, ctx: ctx}
+	b.cond = sync.NewCond(&b.Mutex)
+
+	go func() {
+		<-ctx.Done()
+		b.Lock()
+		b.cond.Broadcast()
+		b.Unlock()
+	}()
+
+	return b
+}
+
+// Acquire block until n bytes are available and return the bytes
+// acquired, n larger than limit is clamped to limit so that it can
+// always be served. false is returned if ctx is done
+func (b *MemoryBudget) Acquire(n int64) (int64, bool) {
+	if b == nil || b.Limit <= 0 {
+		return 0, true
+	}
+	if n > b.Limit {
+		n = b.Limit
+	}
+
+	b.Lock()
+	defer b.Unlock()
+	for b.used+n > b.Limit {
+		if b.ctx.Err() != nil {
+			return 0, false
+		}
+		b.cond.Wait()
+	}
+	b.used += n
+	return n, true
+}
+
+// Release give back n bytes acquired before
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.Lock()
+	b.used -= n
+	b.cond.Broadcast()
+	b.Unlock()
+}
+
+// Used return bytes in flight
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.Lock()
+	defer b.Unlock()
+	return b.used
+}
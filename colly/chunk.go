@@ -0,0 +1,97 @@
+// Stream large file to destination in chunks
+package colly
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// chunkCount return number of chunks of file
+func chunkCount(size int64, chunkSize int64) int {
+	return int((size + chunkSize - 1) / chunkSize)
+}
+
+// chunkFileID identify chunks of one version of file by its path, mtime
+// and size, chunks sent again after a failed pass land in the part file
+// already received instead of leaving it orphan
+func chunkFileID(item FileItem) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", item.FileIndex, item.ModTime, item.FileSize)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// chunkMessageID derive message id of chunk from id of the file
+func chunkMessageID(id string, index int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d", id, index)))
+	return hex.EncodeToString(sum[:16])
+}
+
+// sendChunks read, encode and deliver a large file chunk by chunk, only
+// one chunk of the file is held in memory. nil is returned only when all
+// chunks are acknowledged by backend
+func (c *Collector) sendChunks(backend DestWriter, r EncodeResult) error {
+
+	fd, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != r.Item.FileSize {
+		return fmt.Errorf("file changed while sending, size %d -> %d", r.Item.FileSize, info.Size())
+	}
+
+	count := chunkCount(r.Item.FileSize, c.chunkSize)
+	fileID := chunkFileID(r.Item)
+	messageID := c.encoded(r.Item)
+	fileHash := sha256.New()
+	buf := make([]byte, c.chunkSize)
+
+	for index := 0; index < count; index++ {
+		n, err := io.ReadFull(fd, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		fileHash.Write(buf[:n])
+
+		encoder := &FileContentEncoder{
+			MessageID:   chunkMessageID(messageID, index),
+			FilePath:    r.Item.FileIndex,
+			FileContent: buf[:n],
+			Codec:       c.UserConfigs.CompressCodec,
+			Level:       c.UserConfigs.CompressLevel,
+			ModTime:     r.Item.ModTime / int64(time.Second),
+			Mode:        r.Item.Mode,
+			Owner:       r.Item.Owner,
+			FileID:      fileID,
+			ChunkIndex:  index,
+			ChunkCount:  count,
+			FileSize:    r.Item.FileSize,
+		}
+		if index == count-1 {
+			encoder.FileHash = hex.EncodeToString(fileHash.Sum(nil))
+		}
+
+		packBytes, fields, err := encoder.EncodeFields()
+		if err != nil {
+			return err
+		}
+
+		chunk := r
+		chunk.EncodeContent, chunk.Fields = packBytes, fields
+		if err := c.deliver(backend, chunk); err != nil {
+			return errors.Wrapf(err, "chunk %d/%d", index+1, count)
+		}
+	}
+
+	return nil
+}
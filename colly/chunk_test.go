@@ -0,0 +1,154 @@
+// Test Suit for chunked sending and memory budget
+package colly
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCollector_SendChunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("0123456789")
+	path := filepath.Join(dir, "big.bin")
+	ioutil.WriteFile(path, content, 0644)
+
+	c := newTestCollector(0)
+	c.UserConfigs = &AppConfigOption{}
+	c.chunkSize = 4
+	w := &flakyWriter{}
+
+	item := FileItem{FilePath: path, FileIndex: "/big.bin", FileSize: int64(len(content))}
+	if err := c.sendChunks(w, EncodeResult{Path: path, Item: item, Chunked: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.sent) != 3 {
+		t.Fatalf("expect 3 chunks, got %d", len(w.sent))
+	}
+
+	var joined []byte
+	for i, msg := range w.sent {
+		e, err := DecodeEnvelope([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.ChunkIndex != i || e.ChunkCount != 3 || e.FileSize != 10 || e.FileID == "" {
+			t.Errorf("unexpected chunk envelope: %+v", e)
+		}
+		codec, _ := GetCodec(e.Codec)
+		r, _ := codec.NewReader(bytes.NewReader([]byte(e.Content)))
+		data, _ := ioutil.ReadAll(r)
+		joined = append(joined, data...)
+
+		if i == 2 {
+			hash := sha256.Sum256(content)
+			if e.FileHash != hex.EncodeToString(hash[:]) {
+				t.Error("last chunk should carry file hash")
+			}
+		}
+	}
+	if !bytes.Equal(joined, content) {
+		t.Errorf("reassembled content mismatch: %q", joined)
+	}
+}
+
+func TestMemoryBudget_Acquire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := NewMemoryBudget(ctx, 10)
+
+	if n, ok := b.Acquire(100); !ok || n != 10 {
+		t.Fatalf("large acquire should be clamped, got %d", n)
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		_, ok := b.Acquire(5)
+		acquired <- ok
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquire should block when budget is used up")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.Release(10)
+	if ok := <-acquired; !ok || b.Used() != 5 {
+		t.Errorf("acquire should succeed after release, used: %d", b.Used())
+	}
+
+	go func() {
+		_, ok := b.Acquire(10)
+		acquired <- ok
+	}()
+	cancel()
+	if ok := <-acquired; ok {
+		t.Error("acquire should fail after cancel")
+	}
+}
+
+func TestChunkFileID(t *testing.T) {
+	item := FileItem{FileIndex: "/big.bin", ModTime: 1, FileSize: 10}
+	id := chunkFileID(item)
+	if len(id) != 32 || id != chunkFileID(item) {
+		t.Errorf("chunk file id should be stable, got %s", id)
+	}
+
+	item.ModTime = 2
+	if chunkFileID(item) == id {
+		t.Error("new version of file should have new chunk file id")
+	}
+}
+
+func TestCollector_EncodeFlowSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// file grown since walk
+	small, big := filepath.Join(dir, "small"), filepath.Join(dir, "big")
+	ioutil.WriteFile(small, []byte("012"), 0644)
+	ioutil.WriteFile(big, []byte("0123456789"), 0644)
+
+	router, err := NewRouter(&AppConfigOption{}, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestCollector(0)
+	c.UserConfigs = &AppConfigOption{CompressCodec: "none"}
+	c.Router = router
+	c.chunkSize = 4
+	c.budget = NewMemoryBudget(context.Background(), 100)
+	c.inflight = make(map[string]struct{})
+
+	items := make(chan FileItem, 2)
+	items <- FileItem{FilePath: small, FileIndex: "/small", FileSize: 1}
+	items <- FileItem{FilePath: big, FileIndex: "/big", FileSize: 1}
+	close(items)
+
+	lanes := NewLanes(router.Weights(), 2)
+	c.encodeFlow(items, lanes)
+	lanes.Close()
+
+	r, _ := lanes.Pop()
+	if r.Err != nil || r.Chunked || r.budget != 3 || r.Item.FileSize != 3 {
+		t.Errorf("budget should follow size read: %+v", r)
+	}
+	r, _ = lanes.Pop()
+	if !r.Chunked || r.budget != 4 || r.Item.FileSize != 10 {
+		t.Errorf("grown file should be chunked: %+v", r)
+	}
+}
@@ -0,0 +1,141 @@
+// Claim-check inside redis: message in its own key, reference in queue
+package colly
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis"
+	pkgerrors "github.com/pkg/errors"
+)
+
+// ReferenceRedis is the store of references to redis content keys
+const ReferenceRedis = "redis"
+
+// ErrClaimExpired is returned when the content of a reference has expired
+// before it was fetched, the file is lost
+var ErrClaimExpired = errors.New("claim content has expired")
+
+// ErrClaimMissing is returned when the object of a reference is not in
+// the store, e.g. removed by a lifecycle rule
+var ErrClaimMissing = errors.New("claim content is missing")
+
+// claimKeys return content key of message, counter of stored bytes and
+// expire index of content keys. keys share the queue name as hash tag so
+// they live in one cluster slot with the queue
+func claimKeys(queue string, id string) (content, bytes, index string) {
+	tag := "{" + queue + "}"
+	return tag + ":msg:" + id, QueueBytesKey(queue), tag + ":index"
+}
+
+// ClaimExpiredKey is the counter of contents expired before they were
+// fetched, each one is a lost file
+func ClaimExpiredKey(queue string) string {
+	return "{" + queue + "}:expired"
+}
+
+// claimScript store content with ttl and push its reference in one step.
+// expired contents are dropped from the byte counter and added to the
+// expired counter first, the index
+// member is "size:key" so its size is known after the key is gone. a
+// message already stored is a retry and is not pushed again. the push is
+// refused with -1 if queue length or stored bytes is out of limit, a
+// message larger than the byte limit still goes when nothing is stored
+var claimScript = redis.NewScript(`
+local limit, maxBytes = tonumber(ARGV[1]), tonumber(ARGV[2])
+local ttl, now, size = tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5])
+
+local expired = redis.call("ZRANGEBYSCORE", KEYS[4], "-inf", now)
+for _, member in ipairs(expired) do
+	redis.call("DECRBY", KEYS[3], tonumber(string.match(member, "^%d+")))
+end
+if #expired > 0 then
+	redis.call("ZREMRANGEBYSCORE", KEYS[4], "-inf", now)
+	redis.call("INCRBY", KEYS[5], #expired)
+end
+
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	return redis.call("LLEN", KEYS[1])
+end
+if limit >= 0 and redis.call("LLEN", KEYS[1]) >= limit then
+	return -1
+end
+local stored = tonumber(redis.call("GET", KEYS[3]) or "0")
+if maxBytes > 0 and stored > 0 and stored + size > maxBytes then
+	return -1
+end
+
+local expire = "+inf"
+if ttl > 0 then
+	redis.call("SET", KEYS[2], ARGV[6], "EX", ttl)
+	expire = now + ttl
+else
+	redis.call("SET", KEYS[2], ARGV[6])
+end
+redis.call("INCRBY", KEYS[3], size)
+redis.call("ZADD", KEYS[4], expire, size .. ":" .. KEYS[2])
+return redis.call("LPUSH", KEYS[1], ARGV[7])
+`)
+
+// removeScript delete content and release its bytes, counter is left
+// alone if the content has expired and been counted off by a push
+var removeScript = redis.NewScript(`
+redis.call("DEL", KEYS[1])
+if redis.call("ZREM", KEYS[3], ARGV[1] .. ":" .. KEYS[1]) == 1 then
+	redis.call("DECRBY", KEYS[2], ARGV[1])
+end
+return 0
+`)
+
+// claimArgs build keys and args of claim script for message
+func (w *RedisWriter) claimArgs(buffer string) ([]string, []interface{}, error) {
+	envelope, err := DecodeEnvelope([]byte(buffer))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content, bytes, index := claimKeys(w.DestQueueName, envelope.MessageID)
+	ref, err := envelope.Reference(&Reference{
+		Store:  ReferenceRedis,
+		Bucket: w.DestQueueName,
+		Key:    content,
+		Size:   int64(len(buffer)),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keys := []string{w.DestQueueName, content, bytes, index, ClaimExpiredKey(w.DestQueueName)}
+	args := []interface{}{w.QueueSizeLimit, w.MaxBytes, int64(w.ClaimTTL / time.Second), time.Now().Unix(), len(buffer), buffer, ref}
+	return keys, args, nil
+}
+
+// RedisClaimStore fetch contents referenced from claim-check queue, each
+// content is kept until the file is restored so a failed restore can
+// fetch it again
+type RedisClaimStore struct {
+	Client redis.UniversalClient
+	// references whose content has expired, the files are lost
+	Expired int64
+}
+
+// Fetch read the content reference points to
+func (s *RedisClaimStore) Fetch(ref *Reference) ([]byte, error) {
+	if ref.Store != ReferenceRedis {
+		return nil, fmt.Errorf("reference %s://%s/%s is not in redis", ref.Store, ref.Bucket, ref.Key)
+	}
+	content, err := s.Client.Get(ref.Key).Bytes()
+	if err == redis.Nil {
+		atomic.AddInt64(&s.Expired, 1)
+		return nil, pkgerrors.Wrapf(ErrClaimExpired, "content %s", ref.Key)
+	}
+	return content, err
+}
+
+// Remove delete the content reference points to once it's restored
+func (s *RedisClaimStore) Remove(ref *Reference) error {
+	_, bytes, index := claimKeys(ref.Bucket, "")
+	return removeScript.Run(s.Client, []string{ref.Key, bytes, index}, ref.Size).Err()
+}
@@ -0,0 +1,101 @@
+// Test Suit for redis claim-check
+package colly
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	pkgerrors "github.com/pkg/errors"
+)
+
+func newClaimWriter(t *testing.T, queue string, maxBytes int64) *RedisWriter {
+	w, err := NewRedisWriter(opts, queue, 10)
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	_, bytes, index := claimKeys(queue, "")
+	w.Client.Del(queue, bytes, index, ClaimExpiredKey(queue))
+	w.ClaimCheck = true
+	w.ClaimTTL = time.Hour
+	w.MaxBytes = maxBytes
+	return w
+}
+
+func TestRedisWriter_ClaimCheck(t *testing.T) {
+	queue := DestQueueName + ":claim:redis"
+	message := testMessage(t, "/sub/a.txt")
+	size := int64(len(message))
+	w := newClaimWriter(t, queue, 2*size)
+	defer w.Close()
+
+	errs := w.SendBatch([]string{message, testMessage(t, "/sub/b.txt")})
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatal(errs)
+	}
+	if err := w.SendFileContent(testMessage(t, "/sub/c.txt")); err != ErrQueueFull {
+		t.Fatalf("stored bytes over limit should be refused: %v", err)
+	}
+	// a retry of pushed message is not stored twice
+	if err := w.SendFileContent(message); err != nil {
+		t.Fatal(err)
+	}
+	if w.GetDestQueueSize() != 2 || w.GetDestQueueBytes() != 2*size {
+		t.Fatalf("unexpected queue size %d, stored bytes %d", w.GetDestQueueSize(), w.GetDestQueueBytes())
+	}
+
+	ref, _ := w.Client.RPop(queue).Result()
+	envelope, err := DecodeEnvelope([]byte(ref))
+	if err != nil || envelope.Ref == nil || envelope.Content != "" || envelope.Ref.Store != ReferenceRedis {
+		t.Fatalf("reference should be pushed: %+v, %v", envelope, err)
+	}
+	if ttl := w.Client.TTL(envelope.Ref.Key).Val(); ttl <= 0 || ttl > time.Hour {
+		t.Errorf("content should expire, ttl: %s", ttl)
+	}
+
+	store := &RedisClaimStore{Client: w.Client}
+	content, err := store.Fetch(envelope.Ref)
+	if err != nil || string(content) != message {
+		t.Fatalf("content should be fetched: %v", err)
+	}
+	if _, err := store.Fetch(envelope.Ref); err != nil || w.GetDestQueueBytes() != 2*size {
+		t.Fatalf("content should be kept until removed: %v", err)
+	}
+	if err := store.Remove(envelope.Ref); err != nil {
+		t.Fatal(err)
+	}
+	if w.GetDestQueueBytes() != size || w.Client.Exists(envelope.Ref.Key).Val() != 0 {
+		t.Errorf("removed content should be released, stored bytes %d", w.GetDestQueueBytes())
+	}
+	if _, err := store.Fetch(envelope.Ref); pkgerrors.Cause(err) != ErrClaimExpired || store.Expired != 1 {
+		t.Errorf("content removed should count as expired: %v", err)
+	}
+
+	// the other content expires, a push counts it off
+	ref, _ = w.Client.RPop(queue).Result()
+	envelope, _ = DecodeEnvelope([]byte(ref))
+	_, _, index := claimKeys(queue, "")
+	w.Client.Del(envelope.Ref.Key)
+	w.Client.ZAdd(index, redis.Z{Score: 1, Member: fmt.Sprintf("%d:%s", envelope.Ref.Size, envelope.Ref.Key)})
+	if err := w.SendFileContent(message); err != nil {
+		t.Fatal(err)
+	}
+	if w.GetDestQueueBytes() != size {
+		t.Errorf("expired content should be counted off, stored bytes %d", w.GetDestQueueBytes())
+	}
+	if lost, _ := w.Client.Get(ClaimExpiredKey(queue)).Int64(); lost != 1 {
+		t.Errorf("expired content should be counted as lost: %d", lost)
+	}
+
+	// no expiry by default
+	w.ClaimTTL = 0
+	if err := w.SendFileContent(testMessage(t, "/sub/d.txt")); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ = w.Client.LPop(queue).Result()
+	envelope, _ = DecodeEnvelope([]byte(ref))
+	if ttl := w.Client.TTL(envelope.Ref.Key).Val(); ttl >= 0 {
+		t.Errorf("content should not expire, ttl: %s", ttl)
+	}
+}
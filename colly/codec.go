@@ -0,0 +1,166 @@
+// Compression codec registry for file content
+package colly
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// default codec used when encoder does not specify one
+const (
+	DefaultCodec = "zlib"
+	DefaultLevel = 6
+)
+
+// Codec compress and decompress file content
+type Codec interface {
+	// Name is the codec name recorded in message
+	Name() string
+	// NewWriter compress data written into w with level
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+	// NewReader decompress data read from r
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+// RegisterCodec add codec to registry, codec with same name is replaced
+func RegisterCodec(codec Codec) {
+	codecs.Lock()
+	codecs.m[codec.Name()] = codec
+	codecs.Unlock()
+}
+
+// GetCodec find codec by name
+func GetCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	codec, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown codec: %s, available: %v", name, codecNames())
+	}
+	return codec, nil
+}
+
+// CheckCodec verify codec exists and accepts compress level
+func CheckCodec(name string, level int) error {
+	codec, err := GetCodec(name)
+	if err != nil {
+		return err
+	}
+	w, err := codec.NewWriter(ioutil.Discard, level)
+	if err != nil {
+		return fmt.Errorf("invalid compress level %d of codec %s: %v", level, name, err)
+	}
+	return w.Close()
+}
+
+func codecNames() []string {
+	names := make([]string, 0, len(codecs.m))
+	for name := range codecs.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterCodec(noneCodec{})
+	RegisterCodec(zlibCodec{})
+	RegisterCodec(gzipCodec{})
+	RegisterCodec(flateCodec{})
+	RegisterCodec(snappyCodec{})
+	RegisterCodec(zstdCodec{})
+}
+
+// noneCodec keep content as it is
+type noneCodec struct{}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (noneCodec) Name() string { return "none" }
+func (noneCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+func (noneCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(r), nil
+}
+
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+func (zlibCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, level)
+}
+func (zlibCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, level)
+}
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// flateCodec is raw deflate without header and checksum, the fast
+// choice with level 1
+type flateCodec struct{}
+
+func (flateCodec) Name() string { return "flate" }
+func (flateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return flate.NewWriter(w, level)
+}
+func (flateCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// snappyCodec is the snappy framing format, much faster than deflate
+// with lower ratio, level is ignored
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string { return "snappy" }
+func (snappyCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+func (snappyCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(snappy.NewReader(r)), nil
+}
+
+// zstdCodec is zstandard, better ratio than deflate at similar speed,
+// level 1 to 22 map to the fastest, default, better and best encoder
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level < 1 || level > 22 {
+		return nil, fmt.Errorf("zstd level must be 1 to 22")
+	}
+	return zstd.NewWriter(w,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1))
+}
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return dec.IOReadCloser(), nil
+}
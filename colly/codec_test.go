@@ -0,0 +1,54 @@
+// Test Suit for compress codecs
+package colly
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestCodec_RoundTrip(t *testing.T) {
+	data := []byte("file colly file colly file colly")
+
+	for _, name := range []string{"none", "zlib", "gzip", "flate", "snappy", "zstd"} {
+		codec, err := GetCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		w, err := codec.NewWriter(&buf, 6)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+		w.Close()
+
+		r, err := codec.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("codec %s round trip error: %v, got %q", name, err, got)
+		}
+	}
+
+	if _, err := GetCodec("unknown"); err == nil {
+		t.Error("expect error of unknown codec")
+	}
+}
+
+func TestCheckCodec(t *testing.T) {
+	if err := CheckCodec("zstd", 19); err != nil {
+		t.Error(err)
+	}
+	for _, c := range []struct {
+		name  string
+		level int
+	}{{"zlib", 10}, {"gzip", -3}, {"zstd", 0}, {"zstd", 23}, {"unknown", 6}} {
+		if err := CheckCodec(c.name, c.level); err == nil {
+			t.Errorf("expect error of codec %s level %d", c.name, c.level)
+		}
+	}
+}
@@ -8,9 +8,8 @@ import (
 	"fmt"
 	"sync"
 	"context"
-	"io/ioutil"
+	"io"
 	"github.com/pkg/errors"
-	"github.com/go-redis/redis"
 	"gopkg.in/natefinch/lumberjack.v2"
 	"github.com/smileboywtu/FileColly/common"
 	"time"
@@ -18,6 +17,9 @@ import (
 
 var logger *log.Logger
 
+// logOutput is the rotating log file of logger
+var logOutput io.Writer = os.Stderr
+
 type Collector struct {
 	sync.RWMutex
 
@@ -27,13 +29,42 @@ type Collector struct {
 	// File Walker Instance
 	FileWalkerInst *FileWalker
 
+	// Sent file retention
+	Retention *RetentionManager
+
+	// File lifecycle journal, nil if disabled
+	Journal *Journal
+
+	// Checks that files are completely written, nil if none is set
+	Stability *Stability
+
+	// Destinations kept across passes and routes to them
+	Router *Router
+
 	// File filters
 	Rule    Rule
 	filters []FilterFuncs
 
+	// Files in pipeline
+	inflight map[string]struct{}
+
+	// Delivery progress of file versions not released yet, by journal
+	// key. a file sent again keeps its message id and skips destinations
+	// which acknowledged it
+	progress map[string]*fileProgress
+
+	// Files larger than chunk size are sent in chunks, bytes read
+	// into memory are bounded by budget
+	chunkSize int64
+	budget    *MemoryBudget
+
 	// Files Deal numbers
 	FileCount int64
 
+	// Send retry policy and outcome counters
+	Retry RetryPolicy
+	Stats DeliveryStats
+
 	ctx        context.Context
 	cancleFunc context.CancelFunc
 }
@@ -45,13 +76,21 @@ func InitLogger(logFile string) {
 		fmt.Fprintf(os.Stderr, "open log file error")
 	}
 	logger = log.New(fd, "collector: ", log.Lshortfile)
-	logger.SetOutput(&lumberjack.Logger{
+	output := &lumberjack.Logger{
 		Filename:   logFile,
 		MaxSize:    500, // megabytes
 		MaxBackups: 3,
 		MaxAge:     28,   //days
 		Compress:   true, // disabled by default
-	})
+	}
+	logger.SetOutput(output)
+	logOutput = output
+}
+
+// LogOutput return where collector logs are written, so the application
+// can send logs of its libraries there too
+func LogOutput() io.Writer {
+	return logOutput
 }
 
 // NewCollector init a collector to collect file in directory
@@ -62,6 +101,30 @@ func NewCollector(opts *AppConfigOption) (*Collector, error) {
 	// init logger
 	InitLogger(opts.LogFileName)
 
+	if opts.CompressCodec != "" {
+		if err := CheckCodec(opts.CompressCodec, opts.CompressLevel); err != nil {
+			cancle()
+			return nil, err
+		}
+	}
+
+	if err := CheckOrder(opts.WalkOrder); err != nil {
+		cancle()
+		return nil, err
+	}
+
+	stability, err := NewStability(opts)
+	if err != nil {
+		cancle()
+		return nil, err
+	}
+
+	filter, err := NewWalkFilter(opts)
+	if err != nil {
+		cancle()
+		return nil, err
+	}
+
 	rule := Rule{
 		FileSizeLimit:   common.HumanSize2Bytes(opts.FileMaxSize),
 		ReserveFile:     opts.ReserveFile,
@@ -69,16 +132,125 @@ func NewCollector(opts *AppConfigOption) (*Collector, error) {
 		AllowEmpty:      false,
 	}
 
-	return &Collector{
+	retry := RetryPolicy{
+		MaxRetries: opts.SendRetries,
+		Backoff:    time.Duration(opts.SendRetryBackoff) * time.Millisecond,
+		MaxBackoff: time.Duration(opts.SendRetryMaxBackoff) * time.Millisecond,
+	}
+	router, err := NewRouter(opts, retry)
+	if err != nil {
+		cancle()
+		return nil, err
+	}
+
+	retention := NewRetentionManager(
+		opts.CollectDirectory,
+		opts.SentDirectory,
+		opts.ReserveFile,
+		time.Duration(opts.FileCacheTimeout)*time.Second)
+
+	var journal *Journal
+	if opts.JournalFile != "" {
+		var err error
+		if journal, err = OpenJournal(opts.JournalFile); err != nil {
+			router.Close()
+			cancle()
+			return nil, err
+		}
+	}
+
+	if opts.ErrorDirectory != "" {
+		retention.ErrorDirectory = opts.ErrorDirectory
+	}
+
+	walker := NewDirectoryWorker(opts.CollectDirectory, opts.ReaderMaxWorkers, rule, ctx)
+	walker.Order, walker.OrderWindow = opts.WalkOrder, opts.WalkOrderWindow
+	walker.Filter = filter
+	if stability != nil {
+		walker.OnFilter(stability.Accept)
+	}
+	if opts.ReserveFile {
+		walker.SkipDir(retention.SentDirectory)
+	}
+	walker.SkipDir(retention.ErrorDirectory)
+	// spool destinations may live inside collect directory. a queue limit
+	// of 0 holds collecting, warn in case no limit was meant
+	for name := range router.Destinations {
+		destOpts, err := opts.DestinationOptions(name)
+		if err != nil {
+			continue
+		}
+		if destOpts.SpoolDirectory != "" {
+			walker.SkipDir(destOpts.SpoolDirectory)
+		}
+		if destOpts.DestinationRedisQueueLimit == 0 {
+			logger.Printf("destination %s: dest_queue_limit 0 refuses every push, set -1 for no limit", name)
+		}
+	}
+
+	c := &Collector{
 		UserConfigs:    opts,
-		FileWalkerInst: NewDirectoryWorker(opts.CollectDirectory, opts.ReaderMaxWorkers, rule, ctx),
+		FileWalkerInst: walker,
+		Retention:      retention,
+		Journal:        journal,
+		Stability:      stability,
+		Router:         router,
 		FileCount:      0,
 		Rule:           rule,
+		Retry:          retry,
 		filters:        make([]FilterFuncs, 0, 8),
+		inflight:       make(map[string]struct{}),
+		chunkSize:      common.HumanSize2Bytes(opts.ChunkSize),
+		budget:         NewMemoryBudget(ctx, common.HumanSize2Bytes(opts.MemoryBudget)),
 
 		ctx:        ctx,
 		cancleFunc: cancle,
-	}, nil
+	}
+
+	// finish what last run left before any new pass
+	if err := c.Recover(); err != nil {
+		router.Close()
+		journal.Close()
+		cancle()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Recover replay unfinished journal entries: files already pushed are
+// released without sending again, others are left for next pass
+func (c *Collector) Recover() error {
+	entries, err := c.Journal.Unfinished()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		info, err := os.Stat(entry.Path)
+		current := err == nil && entry.Match(info)
+
+		switch {
+		case current && entry.State == StatePushed:
+			if err := c.Retention.Release(entry.Path); err != nil {
+				// next pass releases it without sending again
+				logger.Printf("recover release file: %s error: %s", entry.Path, err)
+				c.resume(entry)
+				continue
+			}
+			logger.Println("recover released file: ", entry.Path)
+		case current && entry.State == StateEncoded:
+			// sent again with the same message id to destinations which
+			// have not acknowledged it
+			c.resume(entry)
+			continue
+		}
+		if err := c.Journal.Forget(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // OnFilter add new filter to collector
@@ -104,45 +276,127 @@ func (c *Collector) CountClear() {
 	c.Unlock()
 }
 
-// sendPoll send file to
-func (c *Collector) sendPoll(result chan<- EncodeResult, item EncodeResult) {
-	select {
-	case result <- item:
-	case <-c.ctx.Done():
-		return
+// sendPoll send file to lane of its priority class reserved before
+// encoding, the file is left for next pass if lanes are closed
+func (c *Collector) sendPoll(lanes *Lanes, item EncodeResult) {
+	if !lanes.Push(item.Class, item) {
+		c.budget.Release(item.budget)
+		c.unclaim(item.Path)
 	}
 }
 
 // encodeFlow encodes file content and send to backend
-func (c *Collector) encodeFlow(fileItems <-chan FileItem, result chan<- EncodeResult) {
+func (c *Collector) encodeFlow(fileItems <-chan FileItem, result *Lanes) {
 
 	for item := range fileItems {
 
+		// same file from both event and walk
+		if !c.claim(item.FilePath) {
+			continue
+		}
+
+		// a file whose lane is full is left for next pass, so readers go
+		// on with files of other classes
+		class := c.Router.Class(item)
+		if !result.Reserve(class) {
+			c.unclaim(item.FilePath)
+			continue
+		}
+
 		if !c.GetMatch(item.FilePath) {
-			c.sendPoll(result, EncodeResult{item.FilePath, "", errors.New("file not match")})
+			c.sendPoll(result, EncodeResult{Path: item.FilePath, Err: errors.New("file not match"), Item: item, Class: class})
 			continue
 		}
 
-		data, err := ioutil.ReadFile(item.FilePath)
+		// file may change since walk, size and budget follow the file
+		// opened now and no more than budget is read
+		fd, err := os.Open(item.FilePath)
 		if err != nil {
-			c.sendPoll(result, EncodeResult{item.FilePath, "", err})
+			c.sendPoll(result, EncodeResult{Path: item.FilePath, Err: err, Item: item, Class: class})
+			continue
+		}
+		info, err := fd.Stat()
+		if err != nil {
+			fd.Close()
+			c.sendPoll(result, EncodeResult{Path: item.FilePath, Err: err, Item: item, Class: class})
+			continue
+		}
+		item.FileSize, item.ModTime = info.Size(), info.ModTime().UnixNano()
+
+		// large file is read chunk by chunk when sending
+		if c.chunkSize > 0 && item.FileSize > c.chunkSize {
+			fd.Close()
+			budget, ok := c.budget.Acquire(c.chunkSize)
+			if !ok {
+				return
+			}
+			c.sendPoll(result, EncodeResult{Path: item.FilePath, Item: item, Class: class, Chunked: true, budget: budget})
+			continue
+		}
+
+		budget, ok := c.budget.Acquire(item.FileSize)
+		if !ok {
+			fd.Close()
+			return
+		}
+
+		data, err := readAtMost(fd, item.FileSize)
+		fd.Close()
+		if err != nil {
+			c.sendPoll(result, EncodeResult{Path: item.FilePath, Err: err, Item: item, Class: class, budget: budget})
+			continue
 		}
 		encoder := &FileContentEncoder{
+			MessageID:   c.encoded(item),
 			FilePath:    item.FileIndex,
-			FileContent: make([]byte, len(data)),
+			FileContent: data,
+			Codec:       c.UserConfigs.CompressCodec,
+			Level:       c.UserConfigs.CompressLevel,
+			ModTime:     item.ModTime / int64(time.Second),
+			Mode:        item.Mode,
+			Owner:       item.Owner,
 		}
-		copy(encoder.FileContent, data)
-		packBytes, err := encoder.Encode()
-		c.sendPoll(result, EncodeResult{item.FilePath, packBytes, err})
+		packBytes, fields, err := encoder.EncodeFields()
+		c.sendPoll(result, EncodeResult{Path: item.FilePath, EncodeContent: packBytes, Fields: fields, Err: err, Item: item, Class: class, budget: budget})
 	}
 
 }
 
+// readAtMost read file content up to size, a file truncated meanwhile
+// gives the bytes left
+func readAtMost(fd *os.File, size int64) ([]byte, error) {
+	data := make([]byte, size)
+	n, err := io.ReadFull(fd, data)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return data[:n], err
+}
+
+// Start run one pass over the whole collect directory, the pass waits
+// until default destination is available
 func (c *Collector) Start() {
 
-	var wg sync.WaitGroup
-	buffers := make(chan EncodeResult)
+	if _, ok := c.Router.Destinations[DefaultDestination].Wait(c.ctx); !ok {
+		return
+	}
+
 	fileItems, errc := c.FileWalkerInst.Walk()
+	c.run(fileItems)
+
+	if err := <-errc; err != nil {
+		fmt.Println(err.Error())
+		logger.Println(err.Error())
+	}
+
+	c.purge()
+}
+
+// run encode and send file items until the channel is closed
+func (c *Collector) run(fileItems <-chan FileItem) {
+
+	var wg sync.WaitGroup
+	buffers := NewLanes(c.Router.Weights(), c.UserConfigs.SenderMaxWorkers)
 
 	wg.Add(c.UserConfigs.ReaderMaxWorkers)
 	for i := 0; i < c.UserConfigs.ReaderMaxWorkers; i++ {
@@ -151,75 +405,305 @@ func (c *Collector) Start() {
 			wg.Done()
 		}()
 	}
+	done := make(chan struct{})
 	go func() {
 		wg.Wait()
-		close(buffers)
+		close(done)
+	}()
+	go func() {
+		select {
+		case <-done:
+		case <-c.ctx.Done():
+		}
+		buffers.Close()
 	}()
 
 	// wait all buffer deal done
 	c.sendFlow(buffers)
-
-	if err := <-errc; err != nil {
-		fmt.Println(err.Error())
-		logger.Println(err.Error())
-	}
 }
 
-// sendFlow cache current file in pipeline and remove file from directory
-// if queue is out of limit size or reserve file is true, then do nothing
-// about the file
-func (c *Collector) sendFlow(buffers <-chan EncodeResult) {
-
-	redisOpts := &redis.Options{
-		Addr:       fmt.Sprintf("%s:%d", c.UserConfigs.RedisHost, c.UserConfigs.RedisPort),
-		DB:         c.UserConfigs.RedisDB,
-		Password:   c.UserConfigs.RedisPW,
-		MaxRetries: 3,
+// purge remove expired sent files
+func (c *Collector) purge() {
+	if n, err := c.Retention.Purge(); err != nil {
+		logger.Println("purge sent files error: ", err.Error())
+	} else if n > 0 {
+		logger.Printf("purge sent files: %d", n)
 	}
-	backend, errs := NewRedisWriter(
-		redisOpts,
-		c.UserConfigs.DestinationRedisQueueName,
-		c.UserConfigs.DestinationRedisQueueLimit)
+}
 
-	if backend == nil || errs != nil {
-		log.Fatal("redis connect error", errs)
-	}
-	defer backend.Client.Close()
+// sendFlow cache current file in pipeline and release file from directory,
+// if queue is out of limit size then the file is kept for next pass.
+// senders pause while a destination routed to is unavailable, files are
+// taken from priority lanes by weight
+func (c *Collector) sendFlow(buffers *Lanes) {
 
 	c.CountClear()
-	c.IncreaseFileCount(int(backend.GetDestQueueSize()))
 
 	var wg sync.WaitGroup
 	wg.Add(c.UserConfigs.SenderMaxWorkers)
 
 	for i := 0; i < c.UserConfigs.SenderMaxWorkers; i++ {
 		go func() {
-			for r := range buffers {
-				if c.FileCount > int64(c.UserConfigs.DestinationRedisQueueLimit) {
-					if c.FileCount-int64(c.UserConfigs.DestinationRedisQueueLimit) > 10 {
-						c.CountClear()
-						c.IncreaseFileCount(int(backend.GetDestQueueSize()))
-					} else {
-						c.IncreaseFileCount(1)
-						logger.Println("destination redis queue if full")
-						continue
-					}
-				}
-
-				if r.Err == nil {
-					c.IncreaseFileCount(1)
-					backend.SendFileContent(r.EncodeContent)
-
-					os.Remove(r.Path)
-					logger.Println("send file: ", r.Path)
+			for {
+				r, ok := buffers.Pop()
+				if !ok {
+					break
 				}
+				c.sendResult(r)
+				c.budget.Release(r.budget)
+				c.unclaim(r.Path)
 			}
 			wg.Done()
 		}()
 	}
 
 	wg.Wait()
-	logger.Printf("current time: %s, send file total: %d", time.Now().Format("2006-01-02T15:04:05"), c.FileCount)
+	stats := c.Stats.Snapshot()
+	logger.Printf("current time: %s, send file total: %d, sent: %d, retried: %d, gave up: %d",
+		time.Now().Format("2006-01-02T15:04:05"), c.FileCount, stats.Sent, stats.Retried, stats.GaveUp)
+}
+
+// claim mark file as in flight, false if it's already in pipeline
+func (c *Collector) claim(path string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.inflight[path]; ok {
+		return false
+	}
+	c.inflight[path] = struct{}{}
+	return true
+}
+
+// unclaim release file from pipeline
+func (c *Collector) unclaim(path string) {
+	c.Lock()
+	delete(c.inflight, path)
+	c.Unlock()
+}
+
+// fileProgress is delivery progress of one file version
+type fileProgress struct {
+	messageID string
+	acked     map[string]bool
+	pushed    bool
+}
+
+func journalKey(item FileItem) string {
+	return string(NewJournalEntry(item, "").Key())
+}
+
+// mark record file state in journal with its message id and the
+// destinations which acknowledged it, the progress is dropped once the
+// file is deleted
+func (c *Collector) mark(item FileItem, state string) {
+	entry := NewJournalEntry(item, state)
+	key := string(entry.Key())
+
+	c.Lock()
+	if p := c.progress[key]; p != nil {
+		entry.MessageID = p.messageID
+		for name := range p.acked {
+			entry.Acked = append(entry.Acked, name)
+		}
+		p.pushed = p.pushed || state == StatePushed
+	}
+	if state == StateDeleted {
+		delete(c.progress, key)
+	}
+	c.Unlock()
+
+	if err := c.Journal.Mark(entry); err != nil {
+		logger.Printf("journal file: %s state: %s error: %s", item.FilePath, state, err)
+	}
+}
+
+// encoded return the message id to send file version with, recorded in
+// journal before the first push. a file sent again keeps its id so the
+// push is idempotent for claim check and s3 destinations and consumers
+// can tell the duplicate
+func (c *Collector) encoded(item FileItem) string {
+	key := journalKey(item)
+
+	c.Lock()
+	p, ok := c.progress[key]
+	if !ok {
+		if c.progress == nil {
+			c.progress = make(map[string]*fileProgress)
+		}
+		p = &fileProgress{messageID: newMessageID(), acked: make(map[string]bool)}
+		c.progress[key] = p
+	}
+	c.Unlock()
+
+	if !ok {
+		c.mark(item, StateEncoded)
+	}
+	return p.messageID
+}
+
+// resume take progress of file version recorded by last run
+func (c *Collector) resume(entry JournalEntry) {
+	p := &fileProgress{
+		messageID: entry.MessageID,
+		acked:     make(map[string]bool),
+		pushed:    entry.State == StatePushed,
+	}
+	if p.messageID == "" {
+		p.messageID = newMessageID()
+	}
+	for _, name := range entry.Acked {
+		p.acked[name] = true
+	}
+
+	c.Lock()
+	if c.progress == nil {
+		c.progress = make(map[string]*fileProgress)
+	}
+	c.progress[string(entry.Key())] = p
+	c.Unlock()
+}
+
+// pushed check if file version is delivered and only its release failed
+func (c *Collector) pushed(item FileItem) bool {
+	c.RLock()
+	defer c.RUnlock()
+	p := c.progress[journalKey(item)]
+	return p != nil && p.pushed
+}
+
+// ackedBy return destinations which already have this version of file
+func (c *Collector) ackedBy(item FileItem) map[string]bool {
+	c.RLock()
+	defer c.RUnlock()
+	acked := make(map[string]bool)
+	if p := c.progress[journalKey(item)]; p != nil {
+		for name := range p.acked {
+			acked[name] = true
+		}
+	}
+	return acked
+}
+
+// ack record destination has this version of file
+func (c *Collector) ack(item FileItem, name string) {
+	key := journalKey(item)
+
+	c.Lock()
+	defer c.Unlock()
+	if c.progress == nil {
+		c.progress = make(map[string]*fileProgress)
+	}
+	p := c.progress[key]
+	if p == nil {
+		p = &fileProgress{messageID: newMessageID(), acked: make(map[string]bool)}
+		c.progress[key] = p
+	}
+	p.acked[name] = true
+}
+
+// sendResult deliver one encode result to destinations of its route and
+// release the source file once the fan-out is satisfied, file refused by
+// destination is moved into error directory
+func (c *Collector) sendResult(r EncodeResult) {
+	if r.Err != nil {
+		return
+	}
+
+	// file delivered by an earlier pass whose release failed is only
+	// released, it's kept for next pass if destinations not acknowledge
+	if !c.pushed(r.Item) {
+		if err := c.fanout(c.Router.Route(r.Item), r); err != nil {
+			if !IsRejected(err) {
+				return
+			}
+			if err := c.Retention.Reject(r.Path); err != nil {
+				logger.Printf("reject file: %s error: %s", r.Path, err)
+				return
+			}
+			logger.Printf("reject file: %s, moved to: %s", r.Path, c.Retention.ErrorDirectory)
+			c.mark(r.Item, StateDeleted)
+			return
+		}
+		c.IncreaseFileCount(1)
+		c.mark(r.Item, StatePushed)
+	}
+
+	if err := c.Retention.Release(r.Path); err != nil {
+		logger.Printf("release file: %s error: %s", r.Path, err)
+		return
+	}
+	c.mark(r.Item, StateDeleted)
+}
+
+// fanout push result to every destination of route. in all mode it waits
+// for each destination and the file is delivered when all acknowledged,
+// destinations which acknowledged it in an earlier pass are not sent
+// again. in any mode it skips unavailable destinations and one
+// acknowledgement is enough. a rejected error is returned only if no
+// destination failed in a way the file may be sent again
+func (c *Collector) fanout(route *Route, r EncodeResult) error {
+	anyMode := route.Fanout == FanoutAny
+
+	acked := 0
+	var failure, rejection error
+	var delivered map[string]bool
+	if !anyMode {
+		delivered = c.ackedBy(r.Item)
+	}
+	for _, name := range route.To {
+		if delivered[name] {
+			acked++
+			continue
+		}
+		dest := c.Router.Destination(name, r.Class)
+
+		var backend DestWriter
+		var ok bool
+		if anyMode {
+			backend, ok = dest.Ready()
+		} else {
+			backend, ok = dest.Wait(c.ctx)
+		}
+
+		err := fmt.Errorf("destination %s unavailable", name)
+		if ok {
+			if err = c.push(backend, r); err != nil {
+				logger.Printf("send file: %s to destination: %s error: %s", r.Path, name, err)
+				dest.Probe()
+			}
+		}
+
+		switch {
+		case err == nil:
+			acked++
+			if !anyMode {
+				c.ack(r.Item, name)
+			}
+		case IsRejected(err):
+			rejection = err
+		default:
+			failure = err
+		}
+	}
+
+	if acked == len(route.To) || anyMode && acked > 0 {
+		return nil
+	}
+	if acked > 0 {
+		c.mark(r.Item, StateEncoded)
+	}
+	if failure != nil {
+		return failure
+	}
+	return rejection
+}
+
+// push send file as one message or in chunks
+func (c *Collector) push(backend DestWriter, r EncodeResult) error {
+	if r.Chunked {
+		return c.sendChunks(backend, r)
+	}
+	return c.deliver(backend, r)
 }
 
 // GetMatch traverse the filters and check if file should be send
@@ -234,7 +718,21 @@ func (c *Collector) GetMatch(filepath string) bool {
 	return true
 }
 
+// Done is closed when collector is shut down
+func (c *Collector) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
+
 // ShutDown close the file collect daemon
 func (c *Collector) ShutDown() {
 	c.cancleFunc()
 }
+
+// Close release destination and journal after collecting stopped
+func (c *Collector) Close() error {
+	err := c.Router.Close()
+	if jerr := c.Journal.Close(); err == nil {
+		err = jerr
+	}
+	return err
+}
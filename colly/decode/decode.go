@@ -0,0 +1,112 @@
+// Package decode is the consumer side of colly: it pops messages from
+// the queue, validates and decompresses them, reassembles chunked files
+// and writes them into a target directory
+package decode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/smileboywtu/FileColly/colly"
+)
+
+// Message is a validated message with decompressed content
+type Message struct {
+	*colly.Envelope
+
+	// cleaned path relative to collect directory, always slash separated
+	Path    string
+	Content []byte
+}
+
+// Decode unpack message, decompress its content and check the size and
+// hash recorded by collector
+func Decode(data []byte) (*Message, error) {
+	envelope, err := colly.DecodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rawPath, err := envelope.FilePath()
+	if err != nil {
+		return nil, err
+	}
+	if envelope.Ref != nil {
+		return nil, fmt.Errorf("message of %s is a reference to %s, fetch it first", rawPath, envelope.Ref.Key)
+	}
+	relPath, err := CleanPath(rawPath)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := colly.GetCodec(envelope.Codec)
+	if err != nil {
+		return nil, err
+	}
+	reader, err := codec.NewReader(bytes.NewReader([]byte(envelope.Content)))
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s error: %s", relPath, err)
+	}
+	content, err := ioutil.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s error: %s", relPath, err)
+	}
+
+	// legacy message has no size and hash
+	if envelope.Version > 1 {
+		if int64(len(content)) != envelope.Size {
+			return nil, fmt.Errorf("size mismatch of %s: expect %d, got %d", relPath, envelope.Size, len(content))
+		}
+		hash := sha256.Sum256(content)
+		if hex.EncodeToString(hash[:]) != envelope.Hash {
+			return nil, fmt.Errorf("hash mismatch of %s", relPath)
+		}
+	}
+
+	if envelope.IsChunk() {
+		if envelope.ChunkIndex < 0 || envelope.ChunkIndex >= envelope.ChunkCount || !isHex(envelope.FileID) {
+			return nil, fmt.Errorf("invalid chunk %d/%d of %s", envelope.ChunkIndex, envelope.ChunkCount, relPath)
+		}
+	}
+
+	return &Message{
+		Envelope: envelope,
+		Path:     relPath,
+		Content:  content,
+	}, nil
+}
+
+// CleanPath normalize path from message and reject the one which may
+// escape from target directory
+func CleanPath(rawPath string) (string, error) {
+	if strings.ContainsRune(rawPath, 0) {
+		return "", fmt.Errorf("invalid path: %q", rawPath)
+	}
+
+	rawPath = strings.Replace(rawPath, "\\", "/", -1)
+	for _, elem := range strings.Split(rawPath, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("path escapes target directory: %q", rawPath)
+		}
+	}
+
+	cleaned := strings.TrimPrefix(path.Clean("/"+rawPath), "/")
+	if cleaned == "" {
+		return "", fmt.Errorf("empty path: %q", rawPath)
+	}
+	return cleaned, nil
+}
+
+func isHex(s string) bool {
+	if s == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
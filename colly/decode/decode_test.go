@@ -0,0 +1,468 @@
+// Test Suit for consumer decoding
+package decode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/smileboywtu/FileColly/colly"
+)
+
+// redisClient connect local redis, test is skipped without it
+func redisClient(t *testing.T, keys ...string) *redis.Client {
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:6379"})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		t.Skip(err.Error())
+	}
+	client.Del(keys...)
+	return client
+}
+
+// sliceQueue pop messages from memory, requeued ones go to the end
+type sliceQueue struct {
+	messages []string
+	acked    []string
+	rejected []string
+}
+
+func (q *sliceQueue) Pop(timeout time.Duration) (*Delivery, error) {
+	if len(q.messages) == 0 {
+		return nil, ErrEmpty
+	}
+	m := q.messages[0]
+	q.messages = q.messages[1:]
+	return &Delivery{
+		Data:      []byte(m),
+		OnAck:     func() error { q.acked = append(q.acked, m); return nil },
+		OnRequeue: func() error { q.messages = append(q.messages, m); return nil },
+		OnReject:  func() error { q.rejected = append(q.rejected, m); return nil },
+	}, nil
+}
+
+func encode(t *testing.T, encoder *colly.FileContentEncoder) string {
+	packed, err := encoder.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func TestReceive_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	q := &sliceQueue{messages: []string{encode(t, &colly.FileContentEncoder{
+		FilePath:    "/sub/a.txt",
+		FileContent: []byte("hello"),
+		ModTime:     1526523292,
+		Mode:        0600,
+	})}}
+
+	target, done, err := Receive(q, NewRestorer(dir), time.Second)
+	if err != nil || !done {
+		t.Fatal(done, err)
+	}
+	if target != filepath.Join(dir, "sub", "a.txt") {
+		t.Errorf("unexpected target: %s", target)
+	}
+	data, _ := ioutil.ReadFile(target)
+	info, _ := os.Stat(target)
+	if string(data) != "hello" || info.Mode().Perm() != 0600 || info.ModTime().Unix() != 1526523292 {
+		t.Errorf("unexpected restored file: %q %s %s", data, info.Mode(), info.ModTime())
+	}
+
+	if _, _, err := Receive(q, NewRestorer(dir), time.Second); err != ErrEmpty {
+		t.Errorf("expect empty queue, got %v", err)
+	}
+}
+
+func TestRestorer_Chunks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("0123456789")
+	hash := sha256.Sum256(content)
+	chunks := [][]byte{content[:4], content[4:8], content[8:]}
+
+	restorer := NewRestorer(dir)
+	// chunks arrive out of order
+	for n, i := range []int{2, 0, 1} {
+		encoder := &colly.FileContentEncoder{
+			FilePath:    "/big.bin",
+			FileContent: chunks[i],
+			FileID:      "abcd",
+			ChunkIndex:  i,
+			ChunkCount:  3,
+			FileSize:    10,
+		}
+		if i == 2 {
+			encoder.FileHash = hex.EncodeToString(hash[:])
+		}
+		m, err := Decode([]byte(encode(t, encoder)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, done, err := restorer.Restore(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if done != (n == 2) {
+			t.Fatalf("chunk %d: unexpected done %v", i, done)
+		}
+		if done {
+			data, _ := ioutil.ReadFile(target)
+			if !bytes.Equal(data, content) {
+				t.Errorf("unexpected assembled content: %q", data)
+			}
+		}
+	}
+
+	if files, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*")); len(files) != 0 {
+		t.Errorf("temp files left: %v", files)
+	}
+}
+
+func TestRestorer_ChunksRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("0123456789")
+	hash := sha256.Sum256(content)
+	chunks := [][]byte{content[:4], content[4:8], content[8:]}
+	restore := func(restorer *Restorer, i int) bool {
+		encoder := &colly.FileContentEncoder{
+			FilePath:    "/big.bin",
+			FileContent: chunks[i],
+			FileID:      "abcd",
+			ChunkIndex:  i,
+			ChunkCount:  3,
+			FileSize:    10,
+		}
+		if i == 2 {
+			encoder.FileHash = hex.EncodeToString(hash[:])
+		}
+		m, err := Decode([]byte(encode(t, encoder)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, done, err := restorer.Restore(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return done
+	}
+
+	// chunks acked before restart are kept in part file
+	if restore(NewRestorer(dir), 2) {
+		t.Fatal("file should not be done with one chunk")
+	}
+	restorer := NewRestorer(dir)
+	if restore(restorer, 0) || !restore(restorer, 1) {
+		t.Fatal("file should be done once all chunks arrived across restart")
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "big.bin")); !bytes.Equal(data, content) {
+		t.Errorf("unexpected assembled content: %q", data)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, tempPrefix+"*")); len(files) != 0 {
+		t.Errorf("temp files left: %v", files)
+	}
+}
+
+func TestSpoolQueue_Pop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := colly.NewSpoolWriter(filepath.Join(dir, "spool"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/a.txt", "/b.txt"} {
+		if err := w.SendFileContent(encode(t, &colly.FileContentEncoder{FilePath: p, FileContent: []byte(p)})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q := &SpoolQueue{Directory: w.Directory, Interval: time.Millisecond}
+	r := NewRestorer(filepath.Join(dir, "out"))
+	for _, p := range []string{"a.txt", "b.txt"} {
+		target, done, err := Receive(q, r, time.Second)
+		if err != nil || !done || target != filepath.Join(dir, "out", p) {
+			t.Fatalf("receive %s: %s %v %v", p, target, done, err)
+		}
+	}
+	if _, err := q.Pop(10 * time.Millisecond); err != ErrEmpty {
+		t.Errorf("spool should be empty: %v", err)
+	}
+	if infos, _ := ioutil.ReadDir(w.Directory); len(infos) != 0 {
+		t.Errorf("popped files should be removed: %d left", len(infos))
+	}
+}
+
+func TestSpoolQueue_Settle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := colly.NewSpoolWriter(dir, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SendFileContent("a")
+	w.SendFileContent("b")
+	names, _ := colly.SpoolMessages(dir)
+
+	q := &SpoolQueue{Directory: dir, Interval: time.Millisecond}
+	d, err := q.Pop(time.Second)
+	if err != nil || string(d.Data) != "a" {
+		t.Fatalf("pop error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".pop-"+names[0])); err != nil {
+		t.Error("popped file should be kept until settled")
+	}
+	if err := d.Requeue(); err != nil {
+		t.Fatal(err)
+	}
+	if d, _ = q.Pop(time.Second); string(d.Data) != "a" {
+		t.Fatalf("requeued file should be popped again, got %q", d.Data)
+	}
+	d.Reject()
+	if _, err := os.Stat(filepath.Join(dir, ".dead-"+names[0])); err != nil {
+		t.Error("rejected file should be moved aside")
+	}
+
+	// receiver stopped before settling
+	if d, _ = q.Pop(time.Second); string(d.Data) != "b" {
+		t.Fatalf("unexpected message %q", d.Data)
+	}
+	if n, err := q.Recover(); n != 1 || err != nil {
+		t.Fatalf("claimed file should be recovered: %d %v", n, err)
+	}
+	if d, _ = q.Pop(time.Second); string(d.Data) != "b" {
+		t.Fatalf("recovered file should be popped again, got %q", d.Data)
+	}
+	d.Ack()
+	if left, _ := colly.SpoolMessages(dir); len(left) != 0 {
+		t.Errorf("acked file should be removed: %v", left)
+	}
+	if claimed, _ := filepath.Glob(filepath.Join(dir, ".pop-*")); len(claimed) != 0 {
+		t.Errorf("claimed files left: %v", claimed)
+	}
+}
+
+// mapFetcher fetch stored messages by key, removed ones are missing
+type mapFetcher map[string]string
+
+func (f mapFetcher) Fetch(ref *colly.Reference) ([]byte, error) {
+	content, ok := f[ref.Key]
+	if !ok {
+		return nil, colly.ErrClaimMissing
+	}
+	return []byte(content), nil
+}
+
+func (f mapFetcher) Remove(ref *colly.Reference) error {
+	delete(f, ref.Key)
+	return nil
+}
+
+// errFetcher fail every fetch
+type errFetcher struct {
+	err error
+}
+
+func (f errFetcher) Fetch(ref *colly.Reference) ([]byte, error) {
+	return nil, f.err
+}
+
+func TestClaimQueue_Pop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	message := encode(t, &colly.FileContentEncoder{FilePath: "/a.txt", FileContent: []byte("hello")})
+	envelope, _ := colly.DecodeEnvelope([]byte(message))
+	ref, err := envelope.Reference(&colly.Reference{Store: "s3", Key: "a.msg", Size: int64(len(message))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Decode([]byte(ref)); err == nil {
+		t.Error("reference should not be decoded as message")
+	}
+
+	plain := encode(t, &colly.FileContentEncoder{FilePath: "/b.txt", FileContent: []byte("plain")})
+	fetcher := mapFetcher{"a.msg": message}
+	inner := &sliceQueue{messages: []string{ref, plain, ref, ref}}
+	q := &ClaimQueue{Queue: inner, Fetcher: fetcher}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		target, done, err := Receive(q, NewRestorer(dir), time.Second)
+		if err != nil || !done || target != filepath.Join(dir, name) {
+			t.Fatalf("receive %s error: %s, %v", name, target, err)
+		}
+	}
+	if _, ok := fetcher["a.msg"]; ok {
+		t.Error("content should be removed once restored")
+	}
+
+	q.Fetcher = errFetcher{errors.New("connection refused")}
+	if _, err := q.Pop(time.Second); err == nil || len(inner.messages) != 2 {
+		t.Errorf("reference failed to fetch should be requeued: %v", err)
+	}
+	q.Fetcher = mapFetcher{}
+	if _, err := q.Pop(time.Second); err == nil {
+		t.Error("missing content should fail")
+	}
+	q.Fetcher = mapFetcher{"a.msg": "truncated"}
+	if _, err := q.Pop(time.Second); err == nil {
+		t.Error("size mismatch should fail")
+	}
+	if len(inner.acked) != 2 || len(inner.rejected) != 2 || len(inner.messages) != 0 {
+		t.Errorf("restored messages should be acked and bad references rejected: %d acked, %d rejected", len(inner.acked), len(inner.rejected))
+	}
+}
+
+func TestDecode_Reject(t *testing.T) {
+	escape := encode(t, &colly.FileContentEncoder{FilePath: "/../etc/passwd", FileContent: []byte("x")})
+	if _, err := Decode([]byte(escape)); err == nil {
+		t.Error("path escaping target directory should be rejected")
+	}
+
+	e, _ := colly.DecodeEnvelope([]byte(encode(t, &colly.FileContentEncoder{FilePath: "/a", FileContent: []byte("x")})))
+	e.Hash = "00"
+	tampered, _ := e.Marshal()
+	if _, err := Decode([]byte(tampered)); err == nil {
+		t.Error("hash mismatch should be rejected")
+	}
+}
+
+func TestCleanPath(t *testing.T) {
+	cases := map[string]string{
+		"/a/b.txt":   "a/b.txt",
+		"a//b.txt":   "a/b.txt",
+		"/./a/b.txt": "a/b.txt",
+	}
+	for raw, expect := range cases {
+		if got, err := CleanPath(raw); err != nil || got != expect {
+			t.Errorf("clean %q: expect %q, got %q %v", raw, expect, got, err)
+		}
+	}
+	for _, raw := range []string{"", "/", "a/../../b", "..\\b"} {
+		if _, err := CleanPath(raw); err == nil {
+			t.Errorf("clean %q should fail", raw)
+		}
+	}
+}
+
+func TestRedisQueue_Settle(t *testing.T) {
+	name := "cache:decode:queue"
+	processing := ProcessingKey(name, "host")
+	client := redisClient(t, name, processing, DeadKey(name))
+	defer client.Close()
+	defer client.Del(name, processing, DeadKey(name))
+
+	client.LPush(name, "a", "b", "c", "d")
+	q := &RedisQueue{Client: client, Name: name, Consumer: "host"}
+	var ds []*Delivery
+	for range "abcd" {
+		d, err := q.Pop(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ds = append(ds, d)
+	}
+	if n := client.LLen(processing).Val(); n != 4 {
+		t.Fatalf("popped messages should be kept in processing list: %d", n)
+	}
+
+	ds[0].Ack()
+	ds[1].Requeue()
+	ds[2].Reject()
+	if client.LLen(processing).Val() != 1 || client.LIndex(name, 0).Val() != "b" || client.LIndex(DeadKey(name), 0).Val() != "c" {
+		t.Error("settled messages should leave processing list")
+	}
+
+	// d is left by a stopped consumer
+	if n, err := q.Recover(); n != 1 || err != nil {
+		t.Fatal(n, err)
+	}
+	if client.LLen(processing).Val() != 0 || client.LLen(name).Val() != 2 {
+		t.Error("recovered message should be back to queue")
+	}
+}
+
+func TestStreamQueue_Settle(t *testing.T) {
+	name := "cache:decode:stream"
+	client := redisClient(t, name, DeadKey(name))
+	defer client.Close()
+	defer client.Del(name, DeadKey(name))
+
+	q, err := NewStreamQueue(client, name, "colly", "host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewStreamQueue(client, name, "colly", "host"); err != nil {
+		t.Fatal("existing group should be reused: ", err)
+	}
+	for _, m := range []string{"a", "b", "c", "d"} {
+		client.Process(redis.NewStringCmd("XADD", name, "*", "path", m, "payload", m))
+	}
+
+	var ds []*Delivery
+	for _, m := range []string{"a", "b", "c", "d"} {
+		d, err := q.Pop(time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(d.Data) != m {
+			t.Fatalf("expect %s, got %s", m, d.Data)
+		}
+		ds = append(ds, d)
+	}
+	if _, err := q.Pop(10 * time.Millisecond); err != ErrEmpty {
+		t.Fatal("expect empty stream, got ", err)
+	}
+
+	ds[0].Ack()
+	ds[1].Requeue()
+	ds[2].Reject()
+	if d, err := q.Pop(time.Second); err != nil || string(d.Data) != "b" {
+		t.Fatal("requeued entry should be read again: ", err)
+	}
+
+	// b and d are pending
+	if n, err := q.Recover(); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	xlen := func(key string) int64 {
+		cmd := redis.NewIntCmd("XLEN", key)
+		client.Process(cmd)
+		return cmd.Val()
+	}
+	if xlen(name) != 2 || xlen(DeadKey(name)) != 1 {
+		t.Errorf("settled entries should be deleted: %d in stream, %d dead", xlen(name), xlen(DeadKey(name)))
+	}
+}
@@ -0,0 +1,450 @@
+package decode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/smileboywtu/FileColly/colly"
+)
+
+// ErrEmpty is returned when no message arrived before timeout
+var ErrEmpty = errors.New("queue is empty")
+
+// Queue is where the collector messages come from
+type Queue interface {
+	// Pop block until a message is available or timeout, the message
+	// stays in queue until it's settled
+	Pop(timeout time.Duration) (*Delivery, error)
+}
+
+// Recoverer is implemented by queue which keeps messages in flight, it
+// gives back the ones left by a consumer of the same name that stopped
+// before settling them
+type Recoverer interface {
+	Recover() (int, error)
+}
+
+// Delivery is a message popped from queue. Ack removes it once restored,
+// Requeue gives it back for another try and Reject moves it aside for
+// good when it can never be restored
+type Delivery struct {
+	Data []byte
+
+	// settle callbacks of queue, nil for nothing to do
+	OnAck     func() error
+	OnRequeue func() error
+	OnReject  func() error
+}
+
+// Ack remove message from queue
+func (d *Delivery) Ack() error {
+	return settle(d.OnAck)
+}
+
+// Requeue give message back to queue
+func (d *Delivery) Requeue() error {
+	return settle(d.OnRequeue)
+}
+
+// Reject move message to dead letters
+func (d *Delivery) Reject() error {
+	return settle(d.OnReject)
+}
+
+func settle(callback func() error) error {
+	if callback == nil {
+		return nil
+	}
+	return callback()
+}
+
+// ProcessingKey is the list of messages consumer is restoring, it shares
+// the queue name as hash tag to live in one cluster slot with the queue
+func ProcessingKey(queue string, consumer string) string {
+	return "{" + queue + "}:processing:" + consumer
+}
+
+// DeadKey is the list, or stream, of messages which can not be restored
+func DeadKey(queue string) string {
+	return "{" + queue + "}:dead"
+}
+
+// moveScript take message out of processing list and push it to another
+// list, the queue to try again or the dead letters
+var moveScript = redis.NewScript(`
+redis.call("LREM", KEYS[1], 1, ARGV[1])
+return redis.call("LPUSH", KEYS[2], ARGV[1])
+`)
+
+// RedisQueue pop messages from the redis list collector pushes to, each
+// message is moved into processing list of consumer until settled
+type RedisQueue struct {
+	Client   redis.UniversalClient
+	Name     string
+	Consumer string
+}
+
+// Pop take the oldest message of queue
+func (q *RedisQueue) Pop(timeout time.Duration) (*Delivery, error) {
+	processing := ProcessingKey(q.Name, q.Consumer)
+	data, err := q.Client.BRPopLPush(q.Name, processing, timeout).Result()
+	if err == redis.Nil {
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	move := func(to string) func() error {
+		return func() error {
+			return moveScript.Run(q.Client, []string{processing, to}, data).Err()
+		}
+	}
+	return &Delivery{
+		Data: []byte(data),
+		OnAck: func() error {
+			return q.Client.LRem(processing, 1, data).Err()
+		},
+		// tried again after the messages queued now
+		OnRequeue: move(q.Name),
+		OnReject:  move(DeadKey(q.Name)),
+	}, nil
+}
+
+// Recover give messages left in processing list back to queue
+func (q *RedisQueue) Recover() (int, error) {
+	processing := ProcessingKey(q.Name, q.Consumer)
+	for n := 0; ; n++ {
+		err := q.Client.RPopLPush(processing, q.Name).Err()
+		if err == redis.Nil {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// StreamQueue read entries of stream backend in a consumer group, an
+// entry is acked and deleted once restored so stream length counts only
+// the entries not restored yet
+type StreamQueue struct {
+	Client   redis.UniversalClient
+	Name     string
+	Group    string
+	Consumer string
+}
+
+// NewStreamQueue create the consumer group reading from the start of
+// stream if it does not exist
+func NewStreamQueue(client redis.UniversalClient, name string, group string, consumer string) (*StreamQueue, error) {
+	cmd := redis.NewStatusCmd("XGROUP", "CREATE", name, group, "0", "MKSTREAM")
+	client.Process(cmd)
+	if err := cmd.Err(); err != nil && !isBusyGroup(err) {
+		return nil, err
+	}
+	return &StreamQueue{Client: client, Name: name, Group: group, Consumer: consumer}, nil
+}
+
+func isBusyGroup(err error) bool {
+	return len(err.Error()) >= 9 && err.Error()[:9] == "BUSYGROUP"
+}
+
+// streamEntry is an entry read from stream
+type streamEntry struct {
+	id     string
+	fields []interface{}
+	data   []byte
+}
+
+// Pop take next entry never delivered to the group
+func (q *StreamQueue) Pop(timeout time.Duration) (*Delivery, error) {
+	entries, err := q.read(">", 1, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrEmpty
+	}
+	return q.delivery(entries[0]), nil
+}
+
+// Recover add pending entries of consumer to stream again
+func (q *StreamQueue) Recover() (int, error) {
+	n := 0
+	for {
+		// id 0 reads pending entries of this consumer
+		entries, err := q.read("0", 100, -1)
+		if err != nil || len(entries) == 0 {
+			return n, err
+		}
+		for _, entry := range entries {
+			if err := q.delivery(entry).Requeue(); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+}
+
+// read entries of group after id, a negative timeout does not block
+func (q *StreamQueue) read(id string, count int, timeout time.Duration) ([]streamEntry, error) {
+	args := []interface{}{"XREADGROUP", "GROUP", q.Group, q.Consumer, "COUNT", count}
+	if timeout >= 0 {
+		args = append(args, "BLOCK", int64(timeout/time.Millisecond))
+	}
+	args = append(args, "STREAMS", q.Name, id)
+	cmd := redis.NewSliceCmd(args...)
+	q.Client.Process(cmd)
+	result, err := cmd.Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// result is [[stream, [[id, [field, value, ...]], ...]]]
+	var entries []streamEntry
+	for _, stream := range result {
+		reply, ok := stream.([]interface{})
+		if !ok || len(reply) != 2 {
+			return nil, fmt.Errorf("unexpected stream reply: %v", stream)
+		}
+		items, _ := reply[1].([]interface{})
+		for _, item := range items {
+			pair, ok := item.([]interface{})
+			if !ok || len(pair) != 2 {
+				return nil, fmt.Errorf("unexpected stream entry: %v", item)
+			}
+			entry := streamEntry{}
+			entry.id, _ = pair[0].(string)
+			entry.fields, _ = pair[1].([]interface{})
+			for i := 0; i+1 < len(entry.fields); i += 2 {
+				if entry.fields[i] == "payload" {
+					payload, _ := entry.fields[i+1].(string)
+					entry.data = []byte(payload)
+				}
+			}
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// delivery settle entry by acking and deleting it, after adding it again
+// to stream or to dead letters
+func (q *StreamQueue) delivery(entry streamEntry) *Delivery {
+	done := func(to string) func() error {
+		return func() error {
+			pipe := q.Client.TxPipeline()
+			defer pipe.Close()
+			if to != "" {
+				pipe.Process(redis.NewStringCmd(append([]interface{}{"XADD", to, "*"}, entry.fields...)...))
+			}
+			pipe.Process(redis.NewIntCmd("XACK", q.Name, q.Group, entry.id))
+			pipe.Process(redis.NewIntCmd("XDEL", q.Name, entry.id))
+			_, err := pipe.Exec()
+			return err
+		}
+	}
+	return &Delivery{
+		Data:      entry.data,
+		OnAck:     done(""),
+		OnRequeue: done(q.Name),
+		OnReject:  done(DeadKey(q.Name)),
+	}
+}
+
+// SpoolQueue pop message files written by spool backend in sequence
+// order. a popped file is renamed with the claimed prefix and removed
+// only once it's acked
+type SpoolQueue struct {
+	Directory string
+	// poll interval while spool is empty
+	Interval time.Duration
+}
+
+const (
+	spoolClaimedPrefix = ".pop-"
+	spoolDeadPrefix    = ".dead-"
+)
+
+// Pop take the oldest message file of spool directory, it's renamed
+// before reading so concurrent workers never take the same file
+func (q *SpoolQueue) Pop(timeout time.Duration) (*Delivery, error) {
+	interval := q.Interval
+	if interval <= 0 {
+		interval = 100 * time.Millisecond
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		names, err := colly.SpoolMessages(q.Directory)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			claimed := filepath.Join(q.Directory, spoolClaimedPrefix+name)
+			if err := os.Rename(filepath.Join(q.Directory, name), claimed); err != nil {
+				// taken by other worker
+				continue
+			}
+			data, err := ioutil.ReadFile(claimed)
+			if err != nil {
+				q.restore(name)
+				return nil, err
+			}
+			return &Delivery{
+				Data:      data,
+				OnAck:     func() error { return os.Remove(claimed) },
+				OnRequeue: func() error { return q.restore(name) },
+				OnReject: func() error {
+					return os.Rename(claimed, filepath.Join(q.Directory, spoolDeadPrefix+name))
+				},
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, ErrEmpty
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Recover give back files claimed by a receiver that stopped before
+// settling them, only one receiver may run on the directory meanwhile
+func (q *SpoolQueue) Recover() (int, error) {
+	claimed, err := filepath.Glob(filepath.Join(q.Directory, spoolClaimedPrefix+"*"+colly.SpoolSuffix))
+	if err != nil {
+		return 0, err
+	}
+	for i, path := range claimed {
+		if err := q.restore(strings.TrimPrefix(filepath.Base(path), spoolClaimedPrefix)); err != nil {
+			return i, err
+		}
+	}
+	return len(claimed), nil
+}
+
+// restore link claimed file back to its name, a name taken meanwhile is
+// never overwritten
+func (q *SpoolQueue) restore(name string) error {
+	claimed := filepath.Join(q.Directory, spoolClaimedPrefix+name)
+	if err := os.Link(claimed, filepath.Join(q.Directory, name)); err != nil {
+		return err
+	}
+	return os.Remove(claimed)
+}
+
+// Fetcher download message a reference envelope points to
+type Fetcher interface {
+	Fetch(ref *colly.Reference) ([]byte, error)
+}
+
+// Remover is implemented by fetcher which keeps the message until it's
+// restored, it's removed once the reference is acked
+type Remover interface {
+	Remove(ref *colly.Reference) error
+}
+
+// ClaimQueue resolve reference envelopes popped from claim-check queue
+// into the stored messages, other messages pass through
+type ClaimQueue struct {
+	Queue
+	Fetcher Fetcher
+}
+
+// Pop take next message and fetch the content it refers to. a reference
+// whose content is lost or broken is moved to dead letters, one failed to
+// fetch is given back to queue
+func (q *ClaimQueue) Pop(timeout time.Duration) (*Delivery, error) {
+	d, err := q.Queue.Pop(timeout)
+	if err != nil {
+		return nil, err
+	}
+	envelope, err := colly.DecodeEnvelope(d.Data)
+	if err != nil || envelope.Ref == nil {
+		return d, nil
+	}
+
+	ref := envelope.Ref
+	data, err := q.Fetcher.Fetch(ref)
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == colly.ErrClaimExpired || cause == colly.ErrClaimMissing {
+			return nil, settleError(d.Reject, err)
+		}
+		return nil, settleError(d.Requeue, err)
+	}
+	if int64(len(data)) != ref.Size {
+		err = fmt.Errorf("size mismatch of %s: expect %d, got %d", ref.Key, ref.Size, len(data))
+		return nil, settleError(d.Reject, err)
+	}
+
+	ack := d.Ack
+	if remover, ok := q.Fetcher.(Remover); ok {
+		ack = func() error {
+			if err := d.Ack(); err != nil {
+				return err
+			}
+			if err := remover.Remove(ref); err != nil {
+				return fmt.Errorf("remove content %s error: %s", ref.Key, err)
+			}
+			return nil
+		}
+	}
+	return &Delivery{Data: data, OnAck: ack, OnRequeue: d.Requeue, OnReject: d.Reject}, nil
+}
+
+// Receive pop one message from queue, decode and restore it, done is
+// true when the target file is complete. the message is acked once it's
+// restored, given back to queue when writing failed, and moved to dead
+// letters when it can never be restored
+func Receive(q Queue, r *Restorer, timeout time.Duration) (target string, done bool, err error) {
+	d, err := q.Pop(timeout)
+	if err != nil {
+		return "", false, err
+	}
+	m, err := Decode(d.Data)
+	if err != nil {
+		return "", false, settleError(d.Reject, err)
+	}
+
+	target, done, err = r.Restore(m)
+	switch {
+	case err == nil || err == ErrExists:
+		if aerr := d.Ack(); aerr != nil && err == nil {
+			err = fmt.Errorf("settle restored message error: %s", aerr)
+		}
+	case isIOError(err):
+		err = settleError(d.Requeue, err)
+	default:
+		err = settleError(d.Reject, err)
+	}
+	return target, done, err
+}
+
+// settleError settle message after failure, error of queue is added to
+// the cause
+func settleError(action func() error, cause error) error {
+	if err := action(); err != nil {
+		return fmt.Errorf("%s, settle message error: %s", cause, err)
+	}
+	return cause
+}
+
+// isIOError check if restore failed on disk, the message may be restored
+// when tried again
+func isIOError(err error) bool {
+	switch err.(type) {
+	case *os.PathError, *os.LinkError, *os.SyscallError:
+		return true
+	}
+	return false
+}
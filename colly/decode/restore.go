@@ -0,0 +1,289 @@
+package decode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix of temporary files inside target directory
+const tempPrefix = ".colly-"
+
+// policy when target file already exists
+const (
+	PolicyOverwrite = "overwrite"
+	PolicySkip      = "skip"
+)
+
+// ErrExists is returned when target exists and policy is skip
+var ErrExists = errors.New("target file exists")
+
+// Restorer write messages as files under Directory, chunks of a large
+// file are written into a part file and renamed into place when all
+// chunks arrived. chunks received are logged beside the part file, so a
+// restarted restorer resumes it. It's safe for concurrent use
+type Restorer struct {
+	sync.Mutex
+
+	Directory string
+	// incomplete chunked file is dropped after timeout
+	Timeout time.Duration
+	// overwrite or skip existing target file
+	Policy string
+
+	pending map[string]*partFile
+}
+
+type partFile struct {
+	fd *os.File
+	// one line of chunk index, and file hash if known, per chunk synced
+	// into fd
+	log      *os.File
+	received map[int]bool
+	fileHash string
+	updated  time.Time
+}
+
+// NewRestorer create restorer writing into directory
+func NewRestorer(directory string) *Restorer {
+	return &Restorer{
+		Directory: directory,
+		Timeout:   time.Hour,
+		Policy:    PolicyOverwrite,
+		pending:   make(map[string]*partFile),
+	}
+}
+
+// Target return the absolute path of message file in target directory
+func (r *Restorer) Target(m *Message) string {
+	return filepath.Join(r.Directory, filepath.FromSlash(m.Path))
+}
+
+// Restore write message content to disk. the file path is returned with
+// done set to true once the file is complete
+func (r *Restorer) Restore(m *Message) (target string, done bool, err error) {
+	target = r.Target(m)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return target, false, err
+	}
+
+	if !m.IsChunk() {
+		return target, true, r.writeFile(m, target)
+	}
+	return r.writeChunk(m, target)
+}
+
+// writeFile write whole file to a temp file and rename it into place
+func (r *Restorer) writeFile(m *Message, target string) error {
+	fd, err := ioutil.TempFile(filepath.Dir(target), tempPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := fd.Write(m.Content); err != nil {
+		fd.Close()
+		os.Remove(fd.Name())
+		return err
+	}
+	return r.commit(m, fd, target)
+}
+
+// writeChunk write chunk into part file at its offset
+func (r *Restorer) writeChunk(m *Message, target string) (string, bool, error) {
+	part, err := r.part(m.FileID)
+	if err != nil {
+		return target, false, err
+	}
+
+	// all chunks but the last have the same size
+	offset := int64(m.ChunkIndex) * m.Size
+	if m.ChunkIndex == m.ChunkCount-1 {
+		offset = m.FileSize - m.Size
+	}
+	if offset < 0 || offset+m.Size > m.FileSize {
+		return target, false, fmt.Errorf("chunk %d/%d out of file range", m.ChunkIndex, m.ChunkCount)
+	}
+
+	if _, err := part.fd.WriteAt(m.Content, offset); err != nil {
+		return target, false, err
+	}
+	// chunk is acked once this returns, it must be on disk before logged
+	if err := part.fd.Sync(); err != nil {
+		return target, false, err
+	}
+	if err := part.record(m.ChunkIndex, m.FileHash); err != nil {
+		return target, false, err
+	}
+
+	r.Lock()
+	part.received[m.ChunkIndex] = true
+	part.updated = time.Now()
+	if m.FileHash != "" {
+		part.fileHash = m.FileHash
+	}
+	complete := len(part.received) == m.ChunkCount && r.pending[m.FileID] == part
+	if complete {
+		delete(r.pending, m.FileID)
+	}
+	r.Unlock()
+
+	if !complete {
+		return target, false, nil
+	}
+
+	if err := verifyFile(part.fd, m.FileSize, part.fileHash); err != nil {
+		part.remove()
+		return target, false, err
+	}
+	part.log.Close()
+	defer os.Remove(part.log.Name())
+	return target, true, r.commit(m, part.fd, target)
+}
+
+// part return part file of chunked file, the one left by last run is
+// resumed with the chunks it logged
+func (r *Restorer) part(fileID string) (*partFile, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if part, ok := r.pending[fileID]; ok {
+		return part, nil
+	}
+
+	name := filepath.Join(r.Directory, tempPrefix+fileID)
+	fd, err := os.OpenFile(name+".part", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	log, err := os.OpenFile(name+".chunks", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	part := &partFile{fd: fd, log: log, received: make(map[int]bool), updated: time.Now()}
+	if err := part.load(); err != nil {
+		part.remove()
+		return nil, err
+	}
+	r.pending[fileID] = part
+	return part, nil
+}
+
+// load read chunks logged by last run, a line cut by crash is ignored
+func (p *partFile) load() error {
+	data, err := ioutil.ReadAll(p.log)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+	for _, line := range lines[:len(lines)-1] {
+		var index int
+		var hash string
+		if n, _ := fmt.Sscan(line, &index, &hash); n == 0 {
+			continue
+		}
+		p.received[index] = true
+		if hash != "" {
+			p.fileHash = hash
+		}
+	}
+	return nil
+}
+
+// record log chunk as received
+func (p *partFile) record(index int, fileHash string) error {
+	if _, err := fmt.Fprintf(p.log, "%d %s\n", index, fileHash); err != nil {
+		return err
+	}
+	return p.log.Sync()
+}
+
+// remove drop part file and its chunk log
+func (p *partFile) remove() {
+	p.fd.Close()
+	p.log.Close()
+	os.Remove(p.fd.Name())
+	os.Remove(p.log.Name())
+}
+
+// commit set file metadata and rename temp file to target
+func (r *Restorer) commit(m *Message, fd *os.File, target string) error {
+	name := fd.Name()
+	if err := fd.Close(); err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	mode := os.FileMode(m.Mode).Perm()
+	if mode == 0 {
+		mode = 0644
+	}
+	os.Chmod(name, mode)
+	if m.ModTime > 0 {
+		mtime := time.Unix(m.ModTime, 0)
+		os.Chtimes(name, mtime, mtime)
+	}
+
+	if r.Policy == PolicySkip {
+		// link fails if target exists, no race between workers
+		err := os.Link(name, target)
+		os.Remove(name)
+		if os.IsExist(err) {
+			return ErrExists
+		}
+		return err
+	}
+
+	if err := os.Rename(name, target); err != nil {
+		os.Remove(name)
+		return err
+	}
+	return nil
+}
+
+// Expire drop incomplete chunked files not updated within timeout and
+// return the number dropped
+func (r *Restorer) Expire() int {
+	r.Lock()
+	defer r.Unlock()
+
+	expired := 0
+	for fileID, part := range r.pending {
+		if time.Since(part.updated) > r.Timeout {
+			part.remove()
+			delete(r.pending, fileID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// verifyFile check size and hash of assembled file
+func verifyFile(fd *os.File, size int64, hash string) error {
+	info, err := fd.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != size {
+		return fmt.Errorf("assembled size mismatch: expect %d, got %d", size, info.Size())
+	}
+	if hash == "" {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(fd, 0, size)); err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != hash {
+		return fmt.Errorf("assembled hash mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Deliver encoded file to destination with retry
+package colly
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryPolicy define how failed send is retried
+type RetryPolicy struct {
+	// max retry times after the first send
+	MaxRetries int
+	// backoff before the first retry, doubled every retry
+	Backoff time.Duration
+	// upper bound of the backoff
+	MaxBackoff time.Duration
+}
+
+// DeliveryStats count the outcome of every send
+type DeliveryStats struct {
+	Sent    int64
+	Retried int64
+	GaveUp  int64
+}
+
+// Snapshot return a copy of current counters
+func (s *DeliveryStats) Snapshot() DeliveryStats {
+	return DeliveryStats{
+		Sent:    atomic.LoadInt64(&s.Sent),
+		Retried: atomic.LoadInt64(&s.Retried),
+		GaveUp:  atomic.LoadInt64(&s.GaveUp),
+	}
+}
+
+// NextBackoff return wait time before the n-th retry(start from 1)
+func (p RetryPolicy) NextBackoff(n int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < n && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	return wait
+}
+
+// deliver send one encoded file to backend, a nil error means the
+// backend has acknowledged the write and the source can be released
+func (c *Collector) deliver(backend DestWriter, r EncodeResult) error {
+
+	var err error
+	for n := 0; ; n++ {
+		if err = send(backend, r); err == nil {
+			atomic.AddInt64(&c.Stats.Sent, 1)
+			logger.Println("send file: ", r.Path)
+			return nil
+		}
+
+		if n >= c.Retry.MaxRetries || IsRejected(err) {
+			break
+		}
+
+		atomic.AddInt64(&c.Stats.Retried, 1)
+		logger.Printf("retry file: %s, times: %d, error: %s", r.Path, n+1, err)
+
+		// wait asked by destination, e.g. Retry-After, replaces backoff
+		wait := c.Retry.NextBackoff(n + 1)
+		if after := RetryAfter(err); after > 0 {
+			wait = after
+		}
+		select {
+		case <-time.After(wait):
+		case <-c.ctx.Done():
+			atomic.AddInt64(&c.Stats.GaveUp, 1)
+			return errors.Wrap(err, "delivery canceled")
+		}
+	}
+
+	atomic.AddInt64(&c.Stats.GaveUp, 1)
+	logger.Printf("give up file: %s, error: %s", r.Path, err)
+	return err
+}
+
+// send push message to backend, along with its metadata fields if the
+// backend carries them
+func send(backend DestWriter, r EncodeResult) error {
+	if fw, ok := backend.(FieldWriter); ok && r.Fields != nil {
+		return fw.SendFields(r.EncodeContent, r.Fields)
+	}
+	return backend.SendFileContent(r.EncodeContent)
+}
@@ -0,0 +1,92 @@
+// Test Suit for delivery retry
+package colly
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type flakyWriter struct {
+	failures int
+	sent     []string
+}
+
+func (w *flakyWriter) IsAllow() bool           { return true }
+func (w *flakyWriter) GetDestQueueSize() int64 { return int64(len(w.sent)) }
+func (w *flakyWriter) SendFileContent(buffer string) error {
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("redis down")
+	}
+	w.sent = append(w.sent, buffer)
+	return nil
+}
+
+func newTestCollector(retries int) *Collector {
+	InitLogger(filepath.Join(os.TempDir(), "colly_test.log"))
+	return &Collector{
+		Retry: RetryPolicy{MaxRetries: retries, Backoff: time.Millisecond},
+		ctx:   context.Background(),
+	}
+}
+
+func TestCollector_DeliverRetry(t *testing.T) {
+	c := newTestCollector(3)
+	w := &flakyWriter{failures: 2}
+
+	if err := c.deliver(w, EncodeResult{Path: "a", EncodeContent: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	stats := c.Stats.Snapshot()
+	if stats.Sent != 1 || stats.Retried != 2 || stats.GaveUp != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestCollector_DeliverGiveUp(t *testing.T) {
+	c := newTestCollector(1)
+	w := &flakyWriter{failures: 5}
+
+	if err := c.deliver(w, EncodeResult{Path: "a", EncodeContent: "x"}); err == nil {
+		t.Fatal("expect error when backend keeps failing")
+	}
+	stats := c.Stats.Snapshot()
+	if stats.Sent != 0 || stats.Retried != 1 || stats.GaveUp != 1 || len(w.sent) != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestRetryPolicy_NextBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: 300 * time.Millisecond}
+	expects := []time.Duration{100, 200, 300, 300}
+	for i, expect := range expects {
+		if got := p.NextBackoff(i + 1); got != expect*time.Millisecond {
+			t.Errorf("backoff %d: expect %s, got %s", i+1, expect*time.Millisecond, got)
+		}
+	}
+}
+
+func TestCollector_DeliverRejected(t *testing.T) {
+	c := newTestCollector(3)
+	w := &rejectWriter{}
+
+	err := c.deliver(w, EncodeResult{Path: "a", EncodeContent: "x"})
+	if !IsRejected(err) || w.calls != 1 {
+		t.Fatalf("rejected message should not be retried: %v, %d calls", err, w.calls)
+	}
+}
+
+type rejectWriter struct {
+	calls int
+}
+
+func (w *rejectWriter) IsAllow() bool           { return true }
+func (w *rejectWriter) GetDestQueueSize() int64 { return 0 }
+func (w *rejectWriter) SendFileContent(buffer string) error {
+	w.calls++
+	return &RejectedError{Status: 413, Reason: "too large"}
+}
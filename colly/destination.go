@@ -0,0 +1,208 @@
+// Long lived destination shared by collect passes
+package colly
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/smileboywtu/FileColly/common"
+)
+
+// Checker is implemented by backend which can check its connection
+type Checker interface {
+	Check() bool
+}
+
+// Laner is implemented by backend which can send to another queue under
+// another limit on its own connection
+type Laner interface {
+	Lane(queue string, limit int) DestWriter
+}
+
+// Destination own the backend of collector for its whole lifetime. the
+// backend is connected on first use, once it's unreachable senders pause
+// in Wait until it comes back
+type Destination struct {
+	sync.Mutex
+
+	Name string
+
+	// create the backend, the client inside reconnects by itself
+	Connect func() (DestWriter, error)
+	// wait between connect attempts while unavailable
+	Retry RetryPolicy
+
+	// sends are grouped into batches, see Batcher
+	BatchSize   int
+	BatchBytes  int
+	BatchLinger time.Duration
+
+	backend   DestWriter
+	writer    DestWriter
+	available bool
+	down      bool
+	failed    time.Time
+
+	// lane shares backend of parent, it's closed by parent
+	parent *Destination
+}
+
+// NewDestination create destination of config, nothing is connected
+// until the first Wait
+func NewDestination(name string, opts *AppConfigOption, retry RetryPolicy) *Destination {
+	return &Destination{
+		Name: name,
+		Connect: func() (DestWriter, error) {
+			return NewBackend(opts)
+		},
+		Retry:       retry,
+		BatchSize:   opts.BatchSize,
+		BatchBytes:  int(common.HumanSize2Bytes(opts.BatchBytes)),
+		BatchLinger: time.Duration(opts.BatchLinger) * time.Millisecond,
+	}
+}
+
+// Lane create destination sending to queue under limit through backend
+// of d, no connection is opened for it. backend which has no queue is
+// shared as it is
+func (d *Destination) Lane(name string, queue string, limit int) *Destination {
+	lane := &Destination{
+		Name:        name,
+		Retry:       d.Retry,
+		BatchSize:   d.BatchSize,
+		BatchBytes:  d.BatchBytes,
+		BatchLinger: d.BatchLinger,
+		parent:      d,
+	}
+	lane.Connect = func() (DestWriter, error) {
+		d.Lock()
+		defer d.Unlock()
+		if !d.ready() {
+			return nil, fmt.Errorf("destination %s is unavailable", d.Name)
+		}
+		if laner, ok := d.backend.(Laner); ok {
+			return laner.Lane(queue, limit), nil
+		}
+		return d.backend, nil
+	}
+	return lane
+}
+
+// Wait block until destination is available and return the writer to
+// send with, false if ctx is done first
+func (d *Destination) Wait(ctx context.Context) (DestWriter, bool) {
+	for n := 1; ; n++ {
+		if ctx.Err() != nil {
+			return nil, false
+		}
+
+		// lock is not held while sleeping, Ready and Available of other
+		// senders are not blocked by backoff
+		d.Lock()
+		ok, writer := d.ready(), d.writer
+		d.Unlock()
+		if ok {
+			return writer, true
+		}
+
+		select {
+		case <-time.After(d.Retry.NextBackoff(n)):
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// Ready return the writer if destination is available now, it tries to
+// connect at most once per max backoff without waiting
+func (d *Destination) Ready() (DestWriter, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if !d.available && time.Since(d.failed) < d.Retry.MaxBackoff {
+		return nil, false
+	}
+	if !d.ready() {
+		return nil, false
+	}
+	return d.writer, true
+}
+
+// Available report if the last check found destination reachable
+func (d *Destination) Available() bool {
+	d.Lock()
+	defer d.Unlock()
+	return d.available
+}
+
+// Probe check destination after a failed send, following Wait pause if
+// it's unreachable
+func (d *Destination) Probe() {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.available && !d.check() {
+		d.available = false
+		d.down = true
+		d.failed = time.Now()
+		logger.Printf("destination %s unavailable, pause collecting", d.Name)
+	}
+}
+
+// Close flush pending batch and close the backend, lanes must be closed
+// before their parent
+func (d *Destination) Close() error {
+	d.Lock()
+	defer d.Unlock()
+
+	if b, ok := d.writer.(*Batcher); ok {
+		b.Close()
+	}
+	var err error
+	if closer, ok := d.backend.(io.Closer); ok && d.parent == nil {
+		err = closer.Close()
+	}
+	d.backend, d.writer, d.available = nil, nil, false
+	return err
+}
+
+// ready connect or check backend, caller must hold the lock
+func (d *Destination) ready() bool {
+	if d.available {
+		return true
+	}
+
+	if d.backend == nil {
+		backend, err := d.Connect()
+		if err != nil {
+			d.failed = time.Now()
+			if !d.down {
+				d.down = true
+				logger.Printf("destination %s unavailable, pause collecting: %s", d.Name, err)
+			}
+			return false
+		}
+		d.backend = backend
+		d.writer = NewBatcher(backend, d.BatchSize, d.BatchBytes, d.BatchLinger)
+	} else if !d.check() {
+		d.failed = time.Now()
+		return false
+	}
+
+	if d.down {
+		d.down = false
+		logger.Printf("destination %s available, resume collecting", d.Name)
+	}
+	d.available = true
+	return true
+}
+
+func (d *Destination) check() bool {
+	if checker, ok := d.backend.(Checker); ok {
+		return checker.Check()
+	}
+	return true
+}
@@ -0,0 +1,109 @@
+// Test Suit for destination
+package colly
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// downWriter is unreachable while down is set
+type downWriter struct {
+	flakyWriter
+	down   int32
+	closed bool
+}
+
+func (w *downWriter) Check() bool  { return atomic.LoadInt32(&w.down) == 0 }
+func (w *downWriter) Close() error { w.closed = true; return nil }
+
+func newTestDestination(connect func() (DestWriter, error)) *Destination {
+	newTestCollector(0)
+	return &Destination{
+		Connect: connect,
+		Retry:   RetryPolicy{Backoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+}
+
+func TestDestination_WaitConnect(t *testing.T) {
+	backend := &downWriter{}
+	attempts := 0
+	d := newTestDestination(func() (DestWriter, error) {
+		if attempts++; attempts < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return backend, nil
+	})
+
+	w, ok := d.Wait(context.Background())
+	if !ok || w != backend || attempts != 3 {
+		t.Fatalf("destination should connect on third attempt, attempts: %d", attempts)
+	}
+	if _, ok := d.Wait(context.Background()); !ok || attempts != 3 {
+		t.Errorf("available destination should not connect again, attempts: %d", attempts)
+	}
+
+	d.Close()
+	if !backend.closed || d.Available() {
+		t.Error("backend should be closed")
+	}
+}
+
+func TestDestination_WaitCanceled(t *testing.T) {
+	d := newTestDestination(func() (DestWriter, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, ok := d.Wait(ctx); ok {
+		t.Error("wait should give up when canceled")
+	}
+}
+
+func TestDestination_Probe(t *testing.T) {
+	backend := &downWriter{}
+	d := newTestDestination(func() (DestWriter, error) { return backend, nil })
+	if _, ok := d.Wait(context.Background()); !ok {
+		t.Fatal("destination should be available")
+	}
+
+	d.Probe()
+	if !d.Available() {
+		t.Fatal("reachable destination should stay available")
+	}
+
+	atomic.StoreInt32(&backend.down, 1)
+	d.Probe()
+	if d.Available() {
+		t.Fatal("unreachable destination should be paused")
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() { atomic.StoreInt32(&backend.down, 0) })
+	start := time.Now()
+	if _, ok := d.Wait(context.Background()); !ok || time.Since(start) < 20*time.Millisecond {
+		t.Error("wait should block until destination is reachable again")
+	}
+}
+
+func TestDestination_WaitUnlocked(t *testing.T) {
+	d := newTestDestination(func() (DestWriter, error) {
+		return nil, errors.New("connection refused")
+	})
+	d.Retry = RetryPolicy{Backoff: time.Second, MaxBackoff: time.Second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Wait(ctx)
+	time.Sleep(10 * time.Millisecond)
+
+	done := make(chan bool)
+	go func() { done <- d.Available() }()
+	select {
+	case <-done:
+	case <-time.After(100 * time.Millisecond):
+		t.Error("destination should not be locked during backoff")
+	}
+}
@@ -3,45 +3,129 @@ package colly
 
 import (
 	"bytes"
-	"compress/zlib"
+	"crypto/sha256"
 	"encoding/base64"
-	"github.com/vmihailenco/msgpack"
+	"encoding/hex"
+	"time"
 )
 
 type FileContentEncoder struct {
+	// message id, a new one if empty
+	MessageID string
+
 	FilePath    string
 	FileContent []byte
+
+	// compress codec name and level, zlib level 6 if codec is empty
+	Codec string
+	Level int
+
+	// file metadata carried in envelope
+	ModTime int64
+	Mode    uint32
+	Owner   string
+
+	// chunk of large file, ChunkCount is 0 if not chunked
+	FileID     string
+	ChunkIndex int
+	ChunkCount int
+	FileSize   int64
+	FileHash   string
 }
 
 type EncodeResult struct {
 	Path          string
 	EncodeContent string
-	Err           error
+	// metadata of envelope as name, value pairs, see Envelope.Fields
+	Fields []string
+	Err    error
+	Item   FileItem
+
+	// file is too large and sent in chunks by sender
+	Chunked bool
+
+	// priority class index, see Router.Class
+	Class int
+
+	// bytes acquired from memory budget
+	budget int64
 }
 
-// Encode encode data in base64 format and
-// compress use msgpack
-func (c *FileContentEncoder) Encode() (string, error) {
+// Envelope compress file content and wrap it with metadata
+func (c *FileContentEncoder) Envelope() (*Envelope, error) {
+
+	name, level := c.Codec, c.Level
+	if name == "" {
+		name, level = DefaultCodec, DefaultLevel
+	}
+	codec, err := GetCodec(name)
+	if err != nil {
+		return nil, err
+	}
 
 	var buf bytes.Buffer
-	writer, err := zlib.NewWriterLevel(&buf, 6)
+	writer, err := codec.NewWriter(&buf, level)
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	if _, err := writer.Write(c.FileContent); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
 	}
-	writer.Write(c.FileContent)
-	writer.Close()
 
-	b64path := base64.StdEncoding.EncodeToString([]byte(c.FilePath))
+	hash := sha256.Sum256(c.FileContent)
+	id := c.MessageID
+	if id == "" {
+		id = newMessageID()
+	}
 
-	ctx := map[string]string{}
-	ctx["path"] = b64path
-	ctx["content"] = buf.String()
+	return &Envelope{
+		Version:        EnvelopeVersion,
+		MessageID:      id,
+		Path:           base64.StdEncoding.EncodeToString([]byte(c.FilePath)),
+		Content:        buf.String(),
+		Codec:          codec.Name(),
+		Level:          level,
+		Size:           int64(len(c.FileContent)),
+		CompressedSize: int64(buf.Len()),
+		Hash:           hex.EncodeToString(hash[:]),
+		ModTime:        c.ModTime,
+		Mode:           c.Mode,
+		Owner:          c.Owner,
+		FileID:         c.FileID,
+		ChunkIndex:     c.ChunkIndex,
+		ChunkCount:     c.ChunkCount,
+		FileSize:       c.FileSize,
+		FileHash:       c.FileHash,
+		Host:           hostname,
+		CollectTime:    time.Now().Unix(),
+	}, nil
+}
 
-	packBytes, err := msgpack.Marshal(ctx)
+// Encode compress file content with codec and pack it in a msgpack
+// envelope
+func (c *FileContentEncoder) Encode() (string, error) {
+	envelope, err := c.Envelope()
 	if err != nil {
 		return "", err
 	}
+	return envelope.Marshal()
+}
 
-	return string(packBytes[:]), nil
+// EncodeFields encode data as Encode does and return metadata fields of
+// the envelope too, so destinations need not decode the message
+func (c *FileContentEncoder) EncodeFields() (string, []string, error) {
+	envelope, err := c.Envelope()
+	if err != nil {
+		return "", nil, err
+	}
+	fields, err := envelope.Fields()
+	if err != nil {
+		return "", nil, err
+	}
+	packed, err := envelope.Marshal()
+	return packed, fields, err
 }
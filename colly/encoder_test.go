@@ -46,3 +46,66 @@ func BenchmarkFileContentEncoder_Encode40(b *testing.B) {
 func BenchmarkFileContentEncoder_Encode50(b *testing.B) {
 	testFileContentEncoder_Encode([]byte(fake.CharactersN(50)), b)
 }
+
+// codec benchmarks on 64K text, compare throughput(MB/s) and ratio
+//	go test -bench=BenchmarkCodec ./colly -run=BenchmarkCodec
+
+var codecBenchData = func() []byte {
+	data := make([]byte, 0, 64<<10)
+	for len(data) < 64<<10 {
+		data = append(data, fake.Paragraph()...)
+	}
+	return data[:64<<10]
+}()
+
+func benchmarkCodec(name string, level int, b *testing.B) {
+	encoder := FileContentEncoder{
+		FilePath:    "/tmp/a.txt",
+		FileContent: codecBenchData,
+		Codec:       name,
+		Level:       level,
+	}
+
+	var packed string
+	var err error
+	b.SetBytes(int64(len(codecBenchData)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if packed, err = encoder.Encode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(len(codecBenchData))/float64(len(packed)), "ratio")
+}
+
+func BenchmarkCodec_None(b *testing.B) {
+	benchmarkCodec("none", 0, b)
+}
+
+func BenchmarkCodec_Zlib6(b *testing.B) {
+	benchmarkCodec("zlib", 6, b)
+}
+
+func BenchmarkCodec_Gzip6(b *testing.B) {
+	benchmarkCodec("gzip", 6, b)
+}
+
+func BenchmarkCodec_Gzip9(b *testing.B) {
+	benchmarkCodec("gzip", 9, b)
+}
+
+func BenchmarkCodec_Flate1(b *testing.B) {
+	benchmarkCodec("flate", 1, b)
+}
+
+func BenchmarkCodec_Snappy(b *testing.B) {
+	benchmarkCodec("snappy", 0, b)
+}
+
+func BenchmarkCodec_Zstd3(b *testing.B) {
+	benchmarkCodec("zstd", 3, b)
+}
+
+func BenchmarkCodec_Zstd19(b *testing.B) {
+	benchmarkCodec("zstd", 19, b)
+}
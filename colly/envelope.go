@@ -0,0 +1,158 @@
+// Self-describing message sent to destination
+package colly
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+// EnvelopeVersion is the version of envelope written by this collector,
+// version 1 is the legacy form which only has path and content
+const EnvelopeVersion = 2
+
+var hostname, _ = os.Hostname()
+
+// Envelope wrap compressed file content with its metadata
+type Envelope struct {
+	Version   int    `msgpack:"version"`
+	MessageID string `msgpack:"id"`
+
+	// base64 encoded path relative to collect directory
+	Path string `msgpack:"path"`
+	// compressed file content
+	Content string `msgpack:"content"`
+
+	Codec string `msgpack:"codec"`
+	Level int    `msgpack:"level"`
+	// original and compressed size of content in this message
+	Size           int64 `msgpack:"size"`
+	CompressedSize int64 `msgpack:"compressed_size"`
+	// hex sha256 of original content in this message
+	Hash string `msgpack:"hash"`
+
+	// large file is sent as chunk_count chunks sharing file_id, the
+	// whole file size and hash are carried too, file_hash only in the
+	// last chunk. chunk_count is 0 if file is not chunked
+	FileID     string `msgpack:"file_id"`
+	ChunkIndex int    `msgpack:"chunk_index"`
+	ChunkCount int    `msgpack:"chunk_count"`
+	FileSize   int64  `msgpack:"file_size"`
+	FileHash   string `msgpack:"file_hash"`
+
+	// unix time in second
+	ModTime int64  `msgpack:"mtime"`
+	Mode    uint32 `msgpack:"mode"`
+	// uid:gid of the file
+	Owner string `msgpack:"owner"`
+
+	Host        string `msgpack:"host"`
+	CollectTime int64  `msgpack:"collect_time"`
+
+	// set in reference envelope of claim-check destinations, content is
+	// empty and the whole message is stored where it points to
+	Ref *Reference `msgpack:"ref"`
+}
+
+// Reference point to a message kept out of the queue
+type Reference struct {
+	// store kind, e.g. s3
+	Store  string `msgpack:"store"`
+	Bucket string `msgpack:"bucket"`
+	Key    string `msgpack:"key"`
+	// bytes of the stored message
+	Size int64 `msgpack:"size"`
+}
+
+// Marshal pack envelope in msgpack
+func (e *Envelope) Marshal() (string, error) {
+	packBytes, err := msgpack.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return string(packBytes[:]), nil
+}
+
+// Reference pack a reference envelope of this message, metadata is kept
+// so consumers can route it before fetching the content
+func (e *Envelope) Reference(ref *Reference) (string, error) {
+	reference := *e
+	reference.Content = ""
+	reference.Ref = ref
+	return reference.Marshal()
+}
+
+// IsChunk check if message is one chunk of large file
+func (e *Envelope) IsChunk() bool {
+	return e.ChunkCount > 0
+}
+
+// FilePath decode the relative file path
+func (e *Envelope) FilePath() (string, error) {
+	path, err := base64.StdEncoding.DecodeString(e.Path)
+	if err != nil {
+		return "", fmt.Errorf("invalid envelope path: %s", err)
+	}
+	return string(path), nil
+}
+
+// Fields return metadata of envelope as name, value pairs for destinations
+// which carry metadata beside the message
+func (e *Envelope) Fields() ([]string, error) {
+	path, err := e.FilePath()
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []string{
+		"id", e.MessageID,
+		"path", path,
+		"size", strconv.FormatInt(e.Size, 10),
+		"hash", e.Hash,
+		"codec", e.Codec,
+	}
+	if e.IsChunk() {
+		fields = append(fields,
+			"file_id", e.FileID,
+			"chunk_index", strconv.Itoa(e.ChunkIndex),
+			"chunk_count", strconv.Itoa(e.ChunkCount),
+			"file_size", strconv.FormatInt(e.FileSize, 10),
+		)
+	}
+	return fields, nil
+}
+
+// DecodeEnvelope unpack message, legacy message without version is
+// decoded as version 1 with zlib codec
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	e := &Envelope{}
+	if err := msgpack.Unmarshal(data, e); err != nil {
+		return nil, fmt.Errorf("invalid envelope: %s", err)
+	}
+
+	if e.Version == 0 {
+		e.Version = 1
+	}
+	if e.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version: %d", e.Version)
+	}
+	if e.Codec == "" {
+		e.Codec, e.Level = DefaultCodec, DefaultLevel
+	}
+
+	return e, nil
+}
+
+// newMessageID generate random unique message id
+func newMessageID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,54 @@
+// Test Suit for message envelope
+package colly
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	encoder := FileContentEncoder{
+		FilePath:    "/sub/a.txt",
+		FileContent: []byte("hello colly"),
+		Codec:       "gzip",
+		Level:       9,
+		ModTime:     1526523292,
+		Mode:        0644,
+		Owner:       "0:0",
+	}
+	packed, err := encoder.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := DecodeEnvelope([]byte(packed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := e.FilePath()
+	if e.Version != EnvelopeVersion || path != "/sub/a.txt" || e.Codec != "gzip" || e.Level != 9 {
+		t.Errorf("unexpected envelope: %+v", e)
+	}
+	if e.Size != 11 || e.CompressedSize != int64(len(e.Content)) || e.ModTime != 1526523292 ||
+		e.Mode != 0644 || e.Owner != "0:0" || len(e.Hash) != 64 || len(e.MessageID) != 32 {
+		t.Errorf("unexpected envelope metadata: %+v", e)
+	}
+}
+
+func TestEnvelope_DecodeLegacy(t *testing.T) {
+	legacy, _ := msgpack.Marshal(map[string]string{
+		"path":    base64.StdEncoding.EncodeToString([]byte("/a.txt")),
+		"content": "xxx",
+	})
+
+	e, err := DecodeEnvelope(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, _ := e.FilePath()
+	if e.Version != 1 || path != "/a.txt" || e.Content != "xxx" || e.Codec != DefaultCodec {
+		t.Errorf("unexpected legacy envelope: %+v", e)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package colly
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileInode return inode number of file, 0 if unknown
+func fileInode(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
+
+// fileOwner return uid:gid of file, empty if unknown
+func fileOwner(info os.FileInfo) string {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return fmt.Sprintf("%d:%d", stat.Uid, stat.Gid)
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+package colly
+
+import "os"
+
+// fileInode return inode number of file, 0 if unknown
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
+
+// fileOwner return uid:gid of file, empty if unknown
+func fileOwner(info os.FileInfo) string {
+	return ""
+}
@@ -16,15 +16,27 @@ type FileItem struct {
 	FilePath  string
 	FileSize  int64
 	FileIndex string
+	ModTime   int64
+	Inode     uint64
+	Mode      uint32
+	Owner     string
 }
 
 type FileWalker struct {
 	sync.RWMutex
 	Directory     string
 	MaxWalkerSize int
+	skipDirs      []string
 	filters       []FilterFuncs
 	Rule          Rule
 	Ctx           context.Context
+
+	// select files and prune directories by relative path, nil for all
+	Filter *WalkFilter
+
+	// order of files in a pass, applied within a window of items
+	Order       string
+	OrderWindow int
 }
 
 // NewDirectoryWorker create new worker to enumerate files in directory
@@ -38,6 +50,13 @@ func NewDirectoryWorker(directory string, workers int, rule Rule, ctx context.Co
 	}
 }
 
+// SkipDir prune directory and its children from walking
+func (w *FileWalker) SkipDir(dirName string) {
+	w.Lock()
+	w.skipDirs = append(w.skipDirs, filepath.Clean(dirName))
+	w.Unlock()
+}
+
 // OnFilter add file filter to file workers
 func (w *FileWalker) OnFilter(callback FilterFuncs) {
 	w.Lock()
@@ -45,6 +64,68 @@ func (w *FileWalker) OnFilter(callback FilterFuncs) {
 	w.Unlock()
 }
 
+// IsSkipDir check if directory is pruned from walking
+func (w *FileWalker) IsSkipDir(dirName string) bool {
+	dirName = filepath.Clean(dirName)
+	for _, dir := range w.skipDirs {
+		if dirName == dir {
+			return true
+		}
+	}
+	return w.Filter.PruneDir(w.relative(dirName))
+}
+
+// relative return path relative to walked directory, empty for itself
+func (w *FileWalker) relative(path string) string {
+	rel, err := filepath.Rel(filepath.Clean(w.Directory), filepath.Clean(path))
+	if err != nil || rel == "." {
+		return ""
+	}
+	return rel
+}
+
+// Accept check if file pass all the walker filters
+func (w *FileWalker) Accept(path string) bool {
+	for _, filterFunc := range w.filters {
+		if !filterFunc(path, w.Rule) {
+			return false
+		}
+	}
+	return true
+}
+
+// Item build file item of single path, false is returned if the path
+// is not a regular file or filtered
+func (w *FileWalker) Item(path string) (FileItem, bool) {
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return FileItem{}, false
+	}
+	// directories above may be pruned from walking
+	for rel := w.relative(filepath.Dir(path)); rel != "" && rel != "." && !strings.HasPrefix(rel, ".."); rel = filepath.Dir(rel) {
+		if w.IsSkipDir(filepath.Join(w.Directory, rel)) {
+			return FileItem{}, false
+		}
+	}
+	item := w.newItem(path, info)
+	if !w.Filter.Match(item.FileIndex, info) || !w.Accept(path) {
+		return FileItem{}, false
+	}
+	return item, true
+}
+
+func (w *FileWalker) newItem(path string, info os.FileInfo) FileItem {
+	return FileItem{
+		FilePath:  path,
+		FileIndex: w.TrimDirectoryDirectoryPath(path),
+		FileSize:  info.Size(),
+		ModTime:   info.ModTime().UnixNano(),
+		Inode:     fileInode(info),
+		Mode:      uint32(info.Mode()),
+		Owner:     fileOwner(info),
+	}
+}
+
 func (w *FileWalker) WalkDir(dirName string) (<-chan FileItem, <-chan error) {
 
 	files := make(chan FileItem)
@@ -58,23 +139,25 @@ func (w *FileWalker) WalkDir(dirName string) (<-chan FileItem, <-chan error) {
 				return err
 			}
 
+			if info.IsDir() {
+				if w.IsSkipDir(path) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+
 			if !info.Mode().IsRegular() {
 				return nil
 			}
 
 			// filters
-			for _, filterFunc := range w.filters {
-				if !filterFunc(path, w.Rule) {
-					return nil
-				}
+			item := w.newItem(path, info)
+			if !w.Filter.Match(item.FileIndex, info) || !w.Accept(path) {
+				return nil
 			}
 
 			select {
-			case files <- FileItem{
-				FilePath:  path,
-				FileIndex: w.TrimDirectoryDirectoryPath(path),
-				FileSize:  info.Size(),
-			}:
+			case files <- item:
 			case <-w.Ctx.Done():
 				return errors.New("walk canceled")
 			}
@@ -83,7 +166,7 @@ func (w *FileWalker) WalkDir(dirName string) (<-chan FileItem, <-chan error) {
 		})
 	}()
 
-	return files, errc
+	return w.orderItems(files), errc
 }
 
 func (w *FileWalker) Walk() (<-chan FileItem, <-chan error) {
@@ -0,0 +1,269 @@
+// HTTP ingestion endpoint destination
+package colly
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// body formats of http destination
+const (
+	// body is the msgpack envelope, metadata in request headers
+	HTTPFormatMsgpack = "msgpack"
+	// form-data with one part per envelope, metadata in part headers,
+	// batches are sent in one request
+	HTTPFormatMultipart = "multipart"
+)
+
+// prefix of metadata headers, e.g. X-Colly-Id, X-Colly-Chunk-Index
+const httpHeaderPrefix = "X-Colly-"
+
+// HTTPWriter POST messages to an ingestion endpoint. 2xx acknowledges
+// the message, 429 and 5xx fail with the wait of Retry-After so the
+// sender retries after it, other 4xx reject it for good
+type HTTPWriter struct {
+	URL    string
+	Format string
+	Client *http.Client
+
+	// bearer token, or basic auth if username is set
+	Token    string
+	Username string
+	Password string
+}
+
+// NewHTTPWriter init http backend of config
+func NewHTTPWriter(opts *AppConfigOption) (*HTTPWriter, error) {
+	endpoint, err := url.Parse(opts.HTTPURL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid http url: %q", opts.HTTPURL)
+	}
+	if opts.HTTPFormat != HTTPFormatMsgpack && opts.HTTPFormat != HTTPFormatMultipart {
+		return nil, fmt.Errorf("unknown http format: %s", opts.HTTPFormat)
+	}
+
+	transport := &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		MaxIdleConnsPerHost: 16,
+	}
+	if opts.HTTPTLSCA != "" || opts.HTTPTLSSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts.HTTPTLSSkipVerify}
+	}
+	if opts.HTTPTLSCA != "" {
+		pem, err := ioutil.ReadFile(opts.HTTPTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = x509.NewCertPool()
+		if !transport.TLSClientConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", opts.HTTPTLSCA)
+		}
+	}
+
+	return &HTTPWriter{
+		URL:    opts.HTTPURL,
+		Format: opts.HTTPFormat,
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   millisecond(opts.HTTPTimeout),
+		},
+		Token:    opts.HTTPToken,
+		Username: opts.HTTPUsername,
+		Password: opts.HTTPPassword,
+	}, nil
+}
+
+// GetDestQueueSize endpoint has no visible queue
+func (w *HTTPWriter) GetDestQueueSize() int64 {
+	return 0
+}
+
+// IsAllow always allow, endpoint push back with 429
+func (w *HTTPWriter) IsAllow() bool {
+	return true
+}
+
+// SendFileContent POST one message
+func (w *HTTPWriter) SendFileContent(buffer string) error {
+	return w.post([]string{buffer})
+}
+
+// SendBatch POST messages in one multipart request, the response applies
+// to every message. a rejected batch, e.g. 413 of a too large body, is
+// sent again one message per request so only the messages rejected on
+// their own fail. msgpack format has one message per request
+func (w *HTTPWriter) SendBatch(buffers []string) []error {
+	errs := make([]error, len(buffers))
+	if w.Format == HTTPFormatMultipart {
+		err := w.post(buffers)
+		if IsRejected(err) && len(buffers) > 1 {
+			for i, buffer := range buffers {
+				errs[i] = w.post([]string{buffer})
+			}
+			return errs
+		}
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+
+	for i, buffer := range buffers {
+		errs[i] = w.post([]string{buffer})
+	}
+	return errs
+}
+
+// Close release idle connections
+func (w *HTTPWriter) Close() error {
+	if transport, ok := w.Client.Transport.(*http.Transport); ok {
+		transport.CloseIdleConnections()
+	}
+	return nil
+}
+
+// post send messages in one request, retry is left to the sender. the
+// wait asked by Retry-After is returned in RetryAfterError
+func (w *HTTPWriter) post(buffers []string) error {
+	body, header, err := w.encode(buffers)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	} else if w.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.Token)
+	}
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reason, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 256))
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case httpRejected(resp.StatusCode):
+		return &RejectedError{Status: resp.StatusCode, Reason: strings.TrimSpace(string(reason))}
+	}
+	// timeouts, throttling, auth and server errors are not about the
+	// message, retried with backoff
+	err = fmt.Errorf("http status: %s", resp.Status)
+	if wait := retryAfter(resp.Header.Get("Retry-After")); wait > 0 {
+		return &RetryAfterError{Err: err, Wait: wait}
+	}
+	return err
+}
+
+// httpRejected report if status refuse the message itself, sending it
+// again never succeeds
+func httpRejected(status int) bool {
+	switch status {
+	case http.StatusBadRequest,
+		http.StatusRequestEntityTooLarge,
+		http.StatusUnsupportedMediaType,
+		http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// encode build request body and headers of messages
+func (w *HTTPWriter) encode(buffers []string) ([]byte, http.Header, error) {
+	if w.Format == HTTPFormatMsgpack {
+		header, err := envelopeHeader(buffers[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		header.Set("Content-Type", "application/msgpack")
+		return []byte(buffers[0]), header, nil
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, buffer := range buffers {
+		header, err := envelopeHeader(buffer)
+		if err != nil {
+			return nil, nil, err
+		}
+		name, _ := url.PathUnescape(header.Get(httpHeaderPrefix + "Path"))
+		partHeader := textproto.MIMEHeader(header)
+		partHeader.Set("Content-Type", "application/msgpack")
+		partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="message"; filename=%q`, path.Base(name)))
+
+		part, err := form.CreatePart(partHeader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if _, err := io.WriteString(part, buffer); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := form.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", form.FormDataContentType())
+	return body.Bytes(), header, nil
+}
+
+// envelopeHeader copy envelope metadata into headers, path is escaped
+// since it may not be valid in header
+func envelopeHeader(buffer string) (http.Header, error) {
+	envelope, err := DecodeEnvelope([]byte(buffer))
+	if err != nil {
+		return nil, err
+	}
+	fields, err := envelope.Fields()
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		value := fields[i+1]
+		if fields[i] == "path" {
+			value = url.PathEscape(value)
+		}
+		header.Set(httpHeaderPrefix+strings.Replace(fields[i], "_", "-", -1), value)
+	}
+	return header, nil
+}
+
+// retryAfter parse Retry-After in seconds or http date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
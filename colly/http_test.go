@@ -0,0 +1,281 @@
+// Test Suit for http backend
+package colly
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smileboywtu/FileColly/common"
+)
+
+// testServer answer with the statuses in order, the last one repeats
+type testServer struct {
+	sync.Mutex
+	statuses []int
+	header   http.Header
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if mediaType := r.Header.Get("Content-Type"); len(mediaType) > 9 && mediaType[:9] == "multipart" {
+		r.ParseMultipartForm(1 << 20)
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	s.requests = append(s.requests, r)
+	s.bodies = append(s.bodies, body)
+
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+	for key, values := range s.header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(http.StatusText(status)))
+}
+
+func newTestHTTPWriter(t *testing.T, url string, format string) *HTTPWriter {
+	w, err := NewHTTPWriter(&AppConfigOption{
+		HTTPURL:     url,
+		HTTPFormat:  format,
+		HTTPTimeout: 5000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func testMessage(t *testing.T, path string) string {
+	encoder := FileContentEncoder{FilePath: path, FileContent: []byte("hello colly"), Codec: "gzip"}
+	packed, err := encoder.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packed
+}
+
+func TestHTTPWriter_SendFileContent(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}}
+	server.header = http.Header{"Retry-After": {"0"}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	w := newTestHTTPWriter(t, ts.URL, HTTPFormatMsgpack)
+	w.Token = "secret"
+	message := testMessage(t, "/sub/a b.txt")
+	if err := w.SendFileContent(message); err == nil || IsRejected(err) || len(server.requests) != 1 {
+		t.Fatalf("503 should fail once for sender to retry: %v", err)
+	}
+	// collector retries it
+	c := newTestCollector(3)
+	if err := c.deliver(w, EncodeResult{Path: "a b.txt", EncodeContent: message}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(server.requests) != 3 {
+		t.Fatalf("expect 2 retries, got %d requests", len(server.requests))
+	}
+	r := server.requests[2]
+	if r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/msgpack" {
+		t.Errorf("unexpected headers: %v", r.Header)
+	}
+	if r.Header.Get("X-Colly-Path") != "%2Fsub%2Fa%20b.txt" || r.Header.Get("X-Colly-Codec") != "gzip" ||
+		r.Header.Get("X-Colly-Size") != "11" || len(r.Header.Get("X-Colly-Id")) != 32 {
+		t.Errorf("unexpected metadata headers: %v", r.Header)
+	}
+	if string(server.bodies[2]) != message {
+		t.Error("body should be the envelope")
+	}
+}
+
+func TestHTTPWriter_Reject(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusRequestEntityTooLarge}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	w := newTestHTTPWriter(t, ts.URL, HTTPFormatMsgpack)
+	err := w.SendFileContent(testMessage(t, "/a.txt"))
+	if !IsRejected(err) || len(server.requests) != 1 {
+		t.Fatalf("413 should be rejected without retry: %v, %d requests", err, len(server.requests))
+	}
+	if rejected := err.(*RejectedError); rejected.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected rejection: %+v", rejected)
+	}
+}
+
+func TestHTTPWriter_Statuses(t *testing.T) {
+	cases := []struct {
+		status   int
+		rejected bool
+	}{
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+		{http.StatusUnsupportedMediaType, true},
+		{http.StatusUnprocessableEntity, true},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, false},
+		{http.StatusRequestTimeout, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadGateway, false},
+	}
+	for _, tc := range cases {
+		server := &testServer{statuses: []int{tc.status}}
+		ts := httptest.NewServer(server)
+		w := newTestHTTPWriter(t, ts.URL, HTTPFormatMsgpack)
+		err := w.SendFileContent(testMessage(t, "/a.txt"))
+		ts.Close()
+		if err == nil || IsRejected(err) != tc.rejected {
+			t.Errorf("status %d: expect rejected %v, got %v", tc.status, tc.rejected, err)
+		}
+	}
+}
+
+func TestHTTPWriter_RetryAfter(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusServiceUnavailable}}
+	server.header = http.Header{"Retry-After": {"3600"}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	w := newTestHTTPWriter(t, ts.URL, HTTPFormatMsgpack)
+	err := w.SendFileContent(testMessage(t, "/a.txt"))
+	if RetryAfter(err) != time.Hour || IsRejected(err) || len(server.requests) != 1 {
+		t.Fatalf("server wait should be returned: %v", err)
+	}
+
+	// sender waits as long as server asks, until shut down
+	c := newTestCollector(3)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.ctx = ctx
+	start := time.Now()
+	if err := c.deliver(w, EncodeResult{Path: "a.txt", EncodeContent: testMessage(t, "/a.txt")}); err == nil || time.Since(start) > time.Second {
+		t.Errorf("delivery should wait for retry after and stop on cancel: %v", err)
+	}
+	if len(server.requests) != 2 {
+		t.Errorf("no request should be sent before retry after, got %d", len(server.requests))
+	}
+
+	if retryAfter("2") != 2*time.Second || retryAfter("soon") != 0 {
+		t.Error("unexpected retry after")
+	}
+	if wait := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); wait <= 0 || wait > time.Minute {
+		t.Errorf("unexpected retry after of date: %s", wait)
+	}
+}
+
+func TestHTTPWriter_SendBatch(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusAccepted}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	w := newTestHTTPWriter(t, ts.URL, HTTPFormatMultipart)
+	w.Username, w.Password = "colly", "pw"
+	errs := w.SendBatch([]string{testMessage(t, "/a.txt"), testMessage(t, "/sub/b.txt")})
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(server.requests) != 1 {
+		t.Fatalf("batch should be one request, got %d", len(server.requests))
+	}
+	r := server.requests[0]
+	if user, pw, ok := r.BasicAuth(); !ok || user != "colly" || pw != "pw" {
+		t.Error("basic auth should be set")
+	}
+	parts := r.MultipartForm.File["message"]
+	if len(parts) != 2 || parts[0].Filename != "a.txt" || parts[1].Filename != "b.txt" {
+		t.Fatalf("unexpected parts: %v", r.MultipartForm.File)
+	}
+	if parts[1].Header.Get("X-Colly-Path") != "%2Fsub%2Fb.txt" || parts[1].Header.Get("Content-Type") != "application/msgpack" {
+		t.Errorf("unexpected part headers: %v", parts[1].Header)
+	}
+}
+
+func TestHTTPWriter_SendBatchSplit(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusRequestEntityTooLarge, http.StatusOK, http.StatusRequestEntityTooLarge}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	w := newTestHTTPWriter(t, ts.URL, HTTPFormatMultipart)
+	errs := w.SendBatch([]string{testMessage(t, "/a.txt"), testMessage(t, "/b.txt")})
+	if errs[0] != nil || !IsRejected(errs[1]) {
+		t.Fatalf("only the message rejected alone should fail: %v", errs)
+	}
+	if len(server.requests) != 3 || len(server.requests[1].MultipartForm.File["message"]) != 1 {
+		t.Errorf("rejected batch should be sent again one message per request, got %d requests", len(server.requests))
+	}
+}
+
+func TestNewHTTPWriter_Invalid(t *testing.T) {
+	invalid := []AppConfigOption{
+		{HTTPURL: "", HTTPFormat: HTTPFormatMsgpack},
+		{HTTPURL: "ftp://host", HTTPFormat: HTTPFormatMsgpack},
+		{HTTPURL: "http://host", HTTPFormat: "json"},
+		{HTTPURL: "https://host", HTTPFormat: HTTPFormatMsgpack, HTTPTLSCA: "/not/exist"},
+	}
+	for _, opts := range invalid {
+		if _, err := NewHTTPWriter(&opts); err == nil {
+			t.Errorf("options should be rejected: %+v", opts)
+		}
+	}
+}
+
+func TestCollector_StartHTTPReject(t *testing.T) {
+	server := &testServer{statuses: []int{http.StatusRequestEntityTooLarge}}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "sub", "a.txt"), []byte("hello"), 0644)
+
+	opts := &AppConfigOption{}
+	if err := common.ApplyDefaultValues(opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Backend = BackendHTTP
+	opts.HTTPURL = ts.URL
+	opts.CollectDirectory = dir
+	opts.JournalFile = ""
+	opts.LogFileName = filepath.Join(os.TempDir(), "colly_test.log")
+
+	c, err := NewCollector(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+
+	if _, err := os.Stat(filepath.Join(dir, DefaultErrorDirectory, "sub", "a.txt")); err != nil {
+		t.Fatal("rejected file should be moved to error directory: ", err)
+	}
+	if len(server.requests) != 1 || c.GetFileCount() != 0 {
+		t.Errorf("rejected file should be sent once, got %d requests", len(server.requests))
+	}
+
+	// error directory is not collected again
+	c.Start()
+	if len(server.requests) != 1 {
+		t.Errorf("error directory should be skipped, got %d requests", len(server.requests))
+	}
+}
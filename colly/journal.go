@@ -0,0 +1,198 @@
+// Write-ahead journal of file lifecycle
+package colly
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/vmihailenco/msgpack"
+)
+
+// lifecycle of a collected file: encoded with its message id before the
+// first push and with the destinations which acknowledged it while not
+// all did, pushed once delivered and deleted once released. a file only
+// discovered is not recorded, it is simply collected again
+const (
+	StateEncoded = "encoded"
+	StatePushed  = "pushed"
+	StateDeleted = "deleted"
+)
+
+var journalBucket = []byte("files")
+
+type JournalEntry struct {
+	Path    string `msgpack:"path"`
+	Inode   uint64 `msgpack:"inode"`
+	ModTime int64  `msgpack:"mtime"`
+	State   string `msgpack:"state"`
+	Updated int64  `msgpack:"updated"`
+	// message id the file is sent with, kept when it's sent again
+	MessageID string `msgpack:"id"`
+	// destinations which acknowledged the file, when not all did
+	Acked []string `msgpack:"acked"`
+}
+
+// Key identify one version of a file, a file rewritten in place
+// gets a new key
+func (e JournalEntry) Key() []byte {
+	return []byte(fmt.Sprintf("%s\x00%d\x00%d", e.Path, e.Inode, e.ModTime))
+}
+
+// Match check if the file on disk is still the journaled version
+func (e JournalEntry) Match(info os.FileInfo) bool {
+	return info.ModTime().UnixNano() == e.ModTime && fileInode(info) == e.Inode
+}
+
+// NewJournalEntry build entry of file item in given state
+func NewJournalEntry(item FileItem, state string) JournalEntry {
+	return JournalEntry{
+		Path:    item.FilePath,
+		Inode:   item.Inode,
+		ModTime: item.ModTime,
+		State:   state,
+	}
+}
+
+// Journal persist file lifecycle in bolt db, so that a crash between
+// push and release can be recovered on restart. A crash after the push
+// is acknowledged but before it is recorded sends the file again with
+// the same message id. A nil journal is valid and records nothing
+type Journal struct {
+	db *bolt.DB
+
+	marks chan journalMark
+	done  chan struct{}
+}
+
+// journalMark is a mark waiting to be committed
+type journalMark struct {
+	entry  JournalEntry
+	result chan error
+}
+
+// OpenJournal open or create journal db file
+func OpenJournal(path string) (*Journal, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open journal %s error: %s", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(journalBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	j := &Journal{
+		db:    db,
+		marks: make(chan journalMark, 64),
+		done:  make(chan struct{}),
+	}
+	go j.commit()
+	return j, nil
+}
+
+// Mark record the state of file, the deleted state removes the entry
+// since the lifecycle is finished. it returns once the mark is synced,
+// marks of concurrent senders share one sync
+func (j *Journal) Mark(entry JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+
+	m := journalMark{entry: entry, result: make(chan error, 1)}
+	j.marks <- m
+	return <-m.result
+}
+
+// commit write marks in transactions, the marks queued while the last
+// transaction was syncing are written in the next one
+func (j *Journal) commit() {
+	defer close(j.done)
+
+	for m := range j.marks {
+		batch := []journalMark{m}
+	drain:
+		for {
+			select {
+			case m, ok := <-j.marks:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, m)
+			default:
+				break drain
+			}
+		}
+
+		err := j.db.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(journalBucket)
+			for _, m := range batch {
+				if err := putEntry(bucket, m.entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		for _, m := range batch {
+			m.result <- err
+		}
+	}
+}
+
+func putEntry(bucket *bolt.Bucket, entry JournalEntry) error {
+	if entry.State == StateDeleted {
+		return bucket.Delete(entry.Key())
+	}
+
+	entry.Updated = time.Now().Unix()
+	value, err := msgpack.Marshal(&entry)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(entry.Key(), value)
+}
+
+// Forget drop the entry of file which is not delivered, it will be
+// collected again on next pass
+func (j *Journal) Forget(entry JournalEntry) error {
+	entry.State = StateDeleted
+	return j.Mark(entry)
+}
+
+// Unfinished list all entries whose lifecycle is not finished
+func (j *Journal) Unfinished() ([]JournalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+
+	entries := make([]JournalEntry, 0, 8)
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(k, v []byte) error {
+			var entry JournalEntry
+			if err := msgpack.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+
+	return entries, err
+}
+
+// Close wait for pending marks and close the journal db, no mark is
+// allowed after close
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	close(j.marks)
+	<-j.done
+	return j.db.Close()
+}
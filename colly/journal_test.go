@@ -0,0 +1,153 @@
+// Test Suit for file lifecycle journal
+package colly
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func journalItem(t *testing.T, path string) FileItem {
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return FileItem{FilePath: path, ModTime: info.ModTime().UnixNano(), Inode: fileInode(info)}
+}
+
+func TestCollector_RecoverJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal, err := OpenJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	pushed := filepath.Join(dir, "pushed.txt")
+	encoded := filepath.Join(dir, "encoded.txt")
+	ioutil.WriteFile(pushed, []byte("a"), 0644)
+	ioutil.WriteFile(encoded, []byte("b"), 0644)
+
+	journal.Mark(NewJournalEntry(journalItem(t, pushed), StatePushed))
+	entry := NewJournalEntry(journalItem(t, encoded), StateEncoded)
+	entry.MessageID = "0123456789abcdef0123456789abcdef"
+	journal.Mark(entry)
+	gone := NewJournalEntry(FileItem{FilePath: filepath.Join(dir, "gone.txt")}, StateEncoded)
+	journal.Mark(gone)
+	partial := filepath.Join(dir, "partial.txt")
+	ioutil.WriteFile(partial, []byte("c"), 0644)
+	entry = NewJournalEntry(journalItem(t, partial), StateEncoded)
+	entry.Acked = []string{"a"}
+	journal.Mark(entry)
+
+	InitLogger(filepath.Join(os.TempDir(), "colly_test.log"))
+	c := &Collector{
+		Journal:   journal,
+		Retention: NewRetentionManager(dir, "", false, time.Hour),
+		ctx:       context.Background(),
+	}
+	if err := c.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(pushed); !os.IsNotExist(err) {
+		t.Error("pushed file should be released on recover")
+	}
+	if _, err := os.Stat(encoded); err != nil {
+		t.Error("unsent file should be kept for next pass")
+	}
+	if id := c.encoded(journalItem(t, encoded)); id != "0123456789abcdef0123456789abcdef" {
+		t.Errorf("file sent again should keep its message id, got %s", id)
+	}
+	if acked := c.ackedBy(journalItem(t, partial)); !acked["a"] {
+		t.Error("destinations which have partly delivered file should be restored")
+	}
+	if entries, _ := journal.Unfinished(); len(entries) != 2 {
+		t.Errorf("only files still to send should be left in journal, got %v", entries)
+	}
+}
+
+func TestCollector_SendResultReleaseFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(path, []byte("a"), 0644)
+	// sent directory can not be created
+	blocked := filepath.Join(dir, "blocked")
+	ioutil.WriteFile(blocked, nil, 0644)
+
+	w := &flakyWriter{}
+	c := newTestCollector(0)
+	c.Retention = NewRetentionManager(dir, filepath.Join(blocked, "sent"), true, time.Hour)
+	c.Router = &Router{Destinations: map[string]*Destination{
+		DefaultDestination: {Name: DefaultDestination, Connect: func() (DestWriter, error) { return w, nil }},
+	}, fallback: Route{To: []string{DefaultDestination}, Fanout: FanoutAll}}
+
+	item := journalItem(t, path)
+	r := EncodeResult{Path: path, EncodeContent: "x", Item: item}
+	c.encoded(item)
+	c.sendResult(r)
+	if _, err := os.Stat(path); err != nil || len(w.sent) != 1 {
+		t.Fatalf("file should be kept when release fails, sent %d", len(w.sent))
+	}
+
+	// next pass only releases the file
+	c.Retention.SentDirectory = filepath.Join(dir, "sent")
+	c.sendResult(r)
+	if _, err := os.Stat(path); !os.IsNotExist(err) || len(w.sent) != 1 {
+		t.Errorf("pushed file should be released without sending again, sent %d", len(w.sent))
+	}
+	if c.pushed(item) {
+		t.Error("progress should be dropped once released")
+	}
+}
+
+func TestJournal_ConcurrentMark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	journal, err := OpenJournal(filepath.Join(dir, "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := FileItem{FilePath: fmt.Sprintf("/f%d", i)}
+			if err := journal.Mark(NewJournalEntry(item, StatePushed)); err != nil {
+				t.Error(err)
+			}
+			if i%2 == 0 {
+				journal.Mark(NewJournalEntry(item, StateDeleted))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if entries, _ := journal.Unfinished(); len(entries) != 25 {
+		t.Errorf("expect 25 unfinished entries, got %d", len(entries))
+	}
+	if err := journal.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,91 @@
+// Byte and memory limits of redis destinations
+package colly
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// memoryCheckInterval is how often redis used_memory is queried
+const memoryCheckInterval = time.Second
+
+// QueueBytesKey return key counting bytes pushed to queue, it has the
+// queue name as hash tag so it lives in one cluster slot with the queue
+func QueueBytesKey(queue string) string {
+	return "{" + queue + "}:bytes"
+}
+
+// QueueSizesKey return list of message sizes in push order, it's popped
+// from the tail as the queue is, so the consumer need not count off
+func QueueSizesKey(queue string) string {
+	return "{" + queue + "}:sizes"
+}
+
+// trimSizes count off sizes of messages popped since the last push, they
+// are the oldest ones at the tail of size list. KEYS are queue, byte
+// counter and size list, length and bytes are left for the script
+const trimSizes = `
+local length = redis.call("LLEN", KEYS[1])
+local bytes = tonumber(redis.call("GET", KEYS[2]) or "0")
+for i = 1, redis.call("LLEN", KEYS[3]) - length do
+	bytes = bytes - tonumber(redis.call("RPOP", KEYS[3]))
+end
+if bytes < 0 or length == 0 then
+	bytes = 0
+end
+`
+
+// queueBytesScript return bytes of messages in queue
+var queueBytesScript = redis.NewScript(trimSizes + `
+redis.call("SET", KEYS[2], bytes)
+return bytes
+`)
+
+// memoryGuard refuse pushes while redis used_memory is over the ceiling,
+// the last answer is kept for memoryCheckInterval
+type memoryGuard struct {
+	sync.Mutex
+	checked time.Time
+	full    bool
+}
+
+// Full check if used memory of redis is over ceiling, 0 for no ceiling.
+// unknown memory usage does not block pushes
+func (g *memoryGuard) Full(client redis.UniversalClient, ceiling int64) bool {
+	if ceiling <= 0 {
+		return false
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if time.Since(g.checked) < memoryCheckInterval {
+		return g.full
+	}
+	info, err := client.Info("memory").Result()
+	if err != nil {
+		return g.full
+	}
+	g.checked = time.Now()
+	used, ok := usedMemory(info)
+	g.full = ok && used >= ceiling
+	return g.full
+}
+
+// usedMemory parse used_memory of INFO memory
+func usedMemory(info string) (int64, bool) {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "used_memory:") {
+			used, err := strconv.ParseInt(strings.TrimPrefix(line, "used_memory:"), 10, 64)
+			return used, err == nil
+		}
+	}
+	return 0, false
+}
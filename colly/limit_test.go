@@ -0,0 +1,109 @@
+// Test Suit for byte and memory limits
+package colly
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestRedisWriter_MaxBytes(t *testing.T) {
+	queue := DestQueueName + ":bytes"
+	w, err := NewRedisWriter(opts, queue, 10)
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	defer w.Close()
+	w.Client.Del(queue, QueueBytesKey(queue), QueueSizesKey(queue))
+	defer w.Client.Del(queue, QueueBytesKey(queue), QueueSizesKey(queue))
+	w.MaxBytes = 10
+
+	// a message over the limit still goes into empty queue
+	if err := w.SendFileContent("0123456789ab"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendFileContent("x"); err != ErrQueueFull {
+		t.Fatalf("push over max bytes should be refused: %v", err)
+	}
+	if w.GetDestQueueSize() != 1 || w.GetDestQueueBytes() != 12 {
+		t.Fatalf("queue should be full by bytes, size %d, bytes %d", w.GetDestQueueSize(), w.GetDestQueueBytes())
+	}
+
+	// consumers only pop, popped sizes are counted off on next push
+	w.Client.RPop(queue)
+	errs := w.SendBatch([]string{"01234", "56789", "x"})
+	if errs[0] != nil || errs[1] != nil || errs[2] != ErrQueueFull {
+		t.Fatalf("batch should fill the queue to max bytes: %v", errs)
+	}
+	for i := 0; i < 20; i++ {
+		w.Client.RPop(queue)
+		if err := w.SendFileContent("abcde"); err != nil {
+			t.Fatalf("steady load should never stall: %v, bytes %d", err, w.GetDestQueueBytes())
+		}
+	}
+	w.Client.RPop(queue)
+	if w.GetDestQueueSize() != 1 || w.GetDestQueueBytes() != 5 {
+		t.Errorf("unexpected queue size %d, bytes %d", w.GetDestQueueSize(), w.GetDestQueueBytes())
+	}
+
+	// drained, the counter is reset
+	w.Client.Del(queue)
+	if w.GetDestQueueBytes() != 0 || w.Client.LLen(QueueSizesKey(queue)).Val() != 0 {
+		t.Errorf("counter should be reset on empty queue: %d", w.GetDestQueueBytes())
+	}
+}
+
+func TestRedisWriter_MaxMemory(t *testing.T) {
+	var used, infos int64 = 100, 0
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	standIn(l, nil, func(args []string, self string) (interface{}, bool) {
+		switch strings.ToLower(args[0]) {
+		case "ping":
+			return "PONG", true
+		case "info":
+			atomic.AddInt64(&infos, 1)
+			return fmt.Sprintf("# Memory\r\nused_memory:%d\r\nused_memory_human:1K\r\n", atomic.LoadInt64(&used)), true
+		case "evalsha", "eval":
+			return int64(1), true
+		}
+		return nil, false
+	})
+
+	w, err := NewRedisWriterWithClient(redis.NewClient(&redis.Options{Addr: l.Addr().String()}), "q", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.MaxMemory = 1000
+
+	if err := w.SendFileContent("x"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&used, 2000)
+	// answer is cached within check interval
+	if err := w.SendFileContent("x"); err != nil {
+		t.Fatal(err)
+	}
+	w.memory.checked = w.memory.checked.Add(-memoryCheckInterval)
+	if err := w.SendFileContent("x"); err != ErrQueueFull || w.IsAllow() {
+		t.Errorf("push should pause over max memory: %v", err)
+	}
+	if errs := w.SendBatch([]string{"x", "y"}); errs[0] != ErrQueueFull || errs[1] != ErrQueueFull {
+		t.Errorf("batch should pause over max memory: %v", errs)
+	}
+	if n := atomic.LoadInt64(&infos); n != 2 {
+		t.Errorf("expect 2 memory checks, got %d", n)
+	}
+
+	if _, ok := usedMemory("used_memory_rss:1\r\n"); ok {
+		t.Error("used_memory_rss should not be taken as used_memory")
+	}
+}
@@ -2,34 +2,379 @@
 // inter communicate parameter
 package colly
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// redis deployment modes of destination
+const (
+	RedisStandalone = "standalone"
+	RedisSentinel   = "sentinel"
+	RedisCluster    = "cluster"
+)
+
+// redis host with this prefix is a unix socket path
+const unixPrefix = "unix://"
+
 // AppConfigOption define command line args
 type AppConfigOption struct {
-	RedisHost string `yaml:"redis_host" flagName:"redishost" flagSName:"rh" flagDescribe:"Destination Cache Redis host" default:"127.0.0.1"`
+	// host may be a unix socket as unix:///path/to/redis.sock
+	RedisHost string `yaml:"redis_host" flagName:"redishost" flagSName:"rh" flagDescribe:"Destination Cache Redis host or unix:// socket" default:"127.0.0.1"`
 	RedisPort int    `yaml:"redis_port" flagName:"redisport" flagSName:"rp" flagDescribe:"Destination Cache Redis port" default:"6379"`
 	RedisDB   int    `yaml:"redis_db" flagName:"redisdb" flagSName:"rdb" flagDescribe:"Destination Cache Redis db" default:"0"`
 	RedisPW   string `yaml:"redis_passwd" flagName:"redispw" flagSName:"rpwd" flagDescribe:"Destination Cache Redis password" default:""`
 
+	// redis 6 ACL user, password is the one of this user
+	RedisUsername string `yaml:"redis_username" flagName:"redisuser" flagSName:"ruser" flagDescribe:"Destination Cache Redis ACL username" default:""`
+
+	// tls to redis, server certificate is verified by system roots or CA bundle
+	RedisTLS           bool   `yaml:"redis_tls" flagName:"redistls" flagSName:"rtls" flagDescribe:"Connect to Redis with TLS" default:"false"`
+	RedisTLSCA         string `yaml:"redis_tls_ca" flagName:"tlsca" flagSName:"rca" flagDescribe:"CA bundle file to verify Redis server" default:""`
+	RedisTLSCert       string `yaml:"redis_tls_cert" flagName:"tlscert" flagSName:"rcert" flagDescribe:"Client certificate file for Redis TLS" default:""`
+	RedisTLSKey        string `yaml:"redis_tls_key" flagName:"tlskey" flagSName:"rkey" flagDescribe:"Client key file for Redis TLS" default:""`
+	RedisTLSServerName string `yaml:"redis_tls_server_name" flagName:"tlsname" flagSName:"rsni" flagDescribe:"Server name to verify, default to redis host" default:""`
+	RedisTLSSkipVerify bool   `yaml:"redis_tls_skip_verify" flagName:"tlsskip" flagSName:"rtsv" flagDescribe:"Skip Redis server certificate verify, for testing only" default:"false"`
+
+	// sentinel mode finds master by name from sentinels, cluster mode discovers
+	// nodes from seeds, both take comma separated host:port list
+	RedisMode         string `yaml:"redis_mode" flagName:"redismode" flagSName:"rm" flagDescribe:"Destination Redis mode: standalone, sentinel, cluster" default:"standalone"`
+	RedisMasterName   string `yaml:"redis_master_name" flagName:"redismaster" flagSName:"rmn" flagDescribe:"Master name of Redis Sentinel" default:""`
+	RedisSentinels    string `yaml:"redis_sentinels" flagName:"sentinels" flagSName:"rs" flagDescribe:"Comma separated Redis Sentinel addresses" default:""`
+	RedisClusterNodes string `yaml:"redis_cluster_nodes" flagName:"clusternodes" flagSName:"rcn" flagDescribe:"Comma separated Redis Cluster seed addresses" default:""`
+
+	// connection pool of redis client, per node in cluster mode, timeouts in millisecond
+	RedisPoolSize     int `yaml:"redis_pool_size" flagName:"poolsize" flagSName:"rps" flagDescribe:"Redis connection pool size, 0 for 10 per cpu" default:"0"`
+	RedisPoolTimeout  int `yaml:"redis_pool_timeout" flagName:"pooltimeout" flagSName:"rpto" flagDescribe:"Wait in millisecond for a free pooled connection" default:"4000"`
+	RedisDialTimeout  int `yaml:"redis_dial_timeout" flagName:"dialtimeout" flagSName:"rdto" flagDescribe:"Redis connect timeout in millisecond" default:"5000"`
+	RedisReadTimeout  int `yaml:"redis_read_timeout" flagName:"readtimeout" flagSName:"rrto" flagDescribe:"Redis read timeout in millisecond" default:"3000"`
+	RedisWriteTimeout int `yaml:"redis_write_timeout" flagName:"writetimeout" flagSName:"rwto" flagDescribe:"Redis write timeout in millisecond" default:"3000"`
+
+	// list pushes to a redis list, stream adds to a redis stream trimmed by queue limit,
+	// spool writes message files into spool directory capped by queue limit, http POSTs them
+	Backend        string `yaml:"backend" flagName:"backend" flagSName:"b" flagDescribe:"Destination backend: list, stream, spool, http, s3" default:"list"`
+	SpoolDirectory string `yaml:"spool_directory" flagName:"spooldir" flagSName:"spd" flagDescribe:"Directory of spool backend" default:""`
+
+	// http backend POSTs each message to url, or a batch of them in multipart format,
+	// with bearer token or basic auth. timeout in millisecond
+	HTTPURL           string `yaml:"http_url" flagName:"httpurl" flagSName:"hu" flagDescribe:"Endpoint url of http backend" default:""`
+	HTTPFormat        string `yaml:"http_format" flagName:"httpformat" flagSName:"hf" flagDescribe:"Body format of http backend: msgpack, multipart" default:"msgpack"`
+	HTTPToken         string `yaml:"http_token" flagName:"httptoken" flagSName:"htk" flagDescribe:"Bearer token of http backend" default:""`
+	HTTPUsername      string `yaml:"http_username" flagName:"httpuser" flagSName:"hus" flagDescribe:"Basic auth username of http backend" default:""`
+	HTTPPassword      string `yaml:"http_password" flagName:"httppw" flagSName:"hpw" flagDescribe:"Basic auth password of http backend" default:""`
+	HTTPTimeout       int    `yaml:"http_timeout" flagName:"httptimeout" flagSName:"hto" flagDescribe:"Request timeout in millisecond of http backend" default:"30000"`
+	HTTPTLSCA         string `yaml:"http_tls_ca" flagName:"httpca" flagSName:"hca" flagDescribe:"CA bundle file to verify http endpoint" default:""`
+	HTTPTLSSkipVerify bool   `yaml:"http_tls_skip_verify" flagName:"httpskip" flagSName:"hsv" flagDescribe:"Skip http endpoint certificate verify, for testing only" default:"false"`
+
+	// s3 backend uploads each message as an object under prefix, objects over
+	// part size are uploaded in parts. in claim-check mode a reference is
+	// pushed to redis dest queue too
+	S3Endpoint   string `yaml:"s3_endpoint" flagName:"s3endpoint" flagSName:"s3e" flagDescribe:"Endpoint url of s3 backend" default:""`
+	S3Region     string `yaml:"s3_region" flagName:"s3region" flagSName:"s3r" flagDescribe:"Region of s3 backend" default:"us-east-1"`
+	S3Bucket     string `yaml:"s3_bucket" flagName:"s3bucket" flagSName:"s3b" flagDescribe:"Bucket of s3 backend" default:""`
+	S3AccessKey  string `yaml:"s3_access_key" flagName:"s3key" flagSName:"s3ak" flagDescribe:"Access key of s3 backend" default:""`
+	S3SecretKey  string `yaml:"s3_secret_key" flagName:"s3secret" flagSName:"s3sk" flagDescribe:"Secret key of s3 backend" default:""`
+	S3Prefix     string `yaml:"s3_prefix" flagName:"s3prefix" flagSName:"s3p" flagDescribe:"Object key prefix of s3 backend" default:""`
+	S3PartSize   string `yaml:"s3_part_size" flagName:"s3part" flagSName:"s3ps" flagDescribe:"Multipart upload part size of s3 backend, at least 5M" default:"8M"`
+	S3ClaimCheck bool   `yaml:"s3_claim_check" flagName:"s3claim" flagSName:"s3cc" flagDescribe:"Push object reference to redis dest queue" default:"false"`
+	S3Timeout    int    `yaml:"s3_timeout" flagName:"s3timeout" flagSName:"s3to" flagDescribe:"Request timeout in second of s3 backend" default:"300"`
+
 	DestinationRedisQueueName  string `yaml:"dest_queue" flagName:"dqname" flagSName:"dq" flagDescribe:"Destination Redis Queue name" default:"paas:fileserver:files"`
-	DestinationRedisQueueLimit int    `yaml:"dest_queue_limit" flagName:"dqlimit" flagSName:"dql" flagDescribe:"Destination Redis Queue size limit" default:"3000"`
+	DestinationRedisQueueLimit int    `yaml:"dest_queue_limit" flagName:"dqlimit" flagSName:"dql" flagDescribe:"Destination Redis Queue size limit, 0 refuses every push, -1 for no limit" default:"3000"`
+
+	// claim-check mode of list backend stores each message in its own key
+	// expiring after claim ttl and pushes only a reference to dest queue
+	RedisClaimCheck bool `yaml:"redis_claim_check" flagName:"redisclaim" flagSName:"rcc" flagDescribe:"Store messages in own keys and push references to dest queue" default:"false"`
+	// seconds a stored message waits for the consumer, an expired message
+	// is lost unless the file is still kept in sent directory. 0 for cache
+	// timeout, negative for no expiry
+	RedisClaimTTL int `yaml:"redis_claim_ttl" flagName:"claimttl" flagSName:"rct" flagDescribe:"Seconds a claim check message is kept, 0 for cache timeout, negative for no expiry" default:"0"`
+
+	// bytes in list backend queue, or stored in claim-check mode, are capped
+	// by max bytes. pushes pause while redis used_memory is over max memory.
+	// human size, empty for no limit
+	DestinationRedisQueueMaxBytes string `yaml:"dest_queue_max_bytes" flagName:"dqbytes" flagSName:"dqb" flagDescribe:"Max bytes in destination queue in human size" default:""`
+	RedisMaxMemory                string `yaml:"redis_max_memory" flagName:"redismaxmem" flagSName:"rmm" flagDescribe:"Pause pushes while redis used memory is over it, human size" default:""`
+
+	// files are pushed in pipelined batches bounded by count, bytes and linger time in millisecond
+	BatchSize   int    `yaml:"batch_size" flagName:"bsize" flagSName:"bs" flagDescribe:"Max files in one push batch, less than 2 to disable" default:"100"`
+	BatchBytes  string `yaml:"batch_bytes" flagName:"bbytes" flagSName:"bb" flagDescribe:"Max bytes in one push batch in human size" default:"8M"`
+	BatchLinger int    `yaml:"batch_linger" flagName:"blinger" flagSName:"bl" flagDescribe:"Max wait in millisecond to fill a batch" default:"5"`
+
+	// named destinations take the same keys as top level destination options
+	// and inherit the ones not set, routes map files to destinations. yaml only
+	Destinations map[string]map[string]interface{} `yaml:"destinations"`
+	Routes       []Route                           `yaml:"routes"`
+
+	// priority classes of files, each sent through its own weighted lane and
+	// optionally its own queue. yaml only
+	Priorities []Priority `yaml:"priorities"`
+
+	// retry times and backoff in millisecond when send file failed
+	SendRetries         int `yaml:"send_retries" flagName:"sretries" flagSName:"sr" flagDescribe:"Max retry times when send file failed" default:"3"`
+	SendRetryBackoff    int `yaml:"send_retry_backoff" flagName:"sbackoff" flagSName:"sb" flagDescribe:"Backoff in millisecond before first retry" default:"200"`
+	SendRetryMaxBackoff int `yaml:"send_retry_max_backoff" flagName:"smaxbackoff" flagSName:"smb" flagDescribe:"Max backoff in millisecond between retries" default:"5000"`
+
+	// order of files in a walk pass: lexical, mtime (oldest first), size
+	// (smallest first) or random, applied within a window of files
+	WalkOrder       string `yaml:"walk_order" flagName:"order" flagSName:"wo" flagDescribe:"Order of files in a pass: lexical, mtime, size, random" default:"lexical"`
+	WalkOrderWindow int    `yaml:"walk_order_window" flagName:"orderwindow" flagSName:"wow" flagDescribe:"Max files held in memory to order a pass" default:"10000"`
 
 	// wait time in second before reading the file
 	// this make sure the file is ready
 	ReadWaitTime int `yaml:"read_wait_time" flagName:"rwtime" flagSName:"rwt" flagDescribe:"Wait time before file can be read" default:"2"`
 
+	// files to walk by path relative to collect directory: comma separated
+	// globs on file name, or on relative path if they have a slash, and a
+	// regex on relative path. excluded directories are not walked, depth 1
+	// is collect directory only, max age in second
+	WalkInclude      string `yaml:"include" flagName:"include" flagSName:"inc" flagDescribe:"Comma separated globs of files to collect"`
+	WalkExclude      string `yaml:"exclude" flagName:"exclude" flagSName:"exc" flagDescribe:"Comma separated globs of files to skip"`
+	WalkIncludeRegex string `yaml:"include_regex" flagName:"iregex" flagSName:"ire" flagDescribe:"Regex on relative path of files to collect"`
+	WalkExcludeRegex string `yaml:"exclude_regex" flagName:"eregex" flagSName:"ere" flagDescribe:"Regex on relative path of files to skip"`
+	WalkExcludeDirs  string `yaml:"exclude_dirs" flagName:"xdirs" flagSName:"xd" flagDescribe:"Comma separated globs of directories not walked"`
+	WalkMaxDepth     int    `yaml:"max_depth" flagName:"depth" flagSName:"md" flagDescribe:"Max directory levels to walk, 0 for no limit" default:"0"`
+	WalkMinSize      string `yaml:"min_file_size" flagName:"minsize" flagSName:"mins" flagDescribe:"Min file size in human size"`
+	WalkMaxAge       int    `yaml:"max_file_age" flagName:"maxage" flagSName:"ma" flagDescribe:"Skip files modified more than seconds ago, 0 for no limit" default:"0"`
+
+	// stability checks beyond read wait time: comma separated globs of temp
+	// file names, comma separated marker suffixes a file waits for, size and
+	// mtime unchanged across two observations, no process writing the file
+	TempPatterns    string `yaml:"temp_patterns" flagName:"temp" flagSName:"tmp" flagDescribe:"Comma separated globs of temp file names to skip"`
+	StableMarkers   string `yaml:"stable_markers" flagName:"markers" flagSName:"mk" flagDescribe:"Comma separated marker suffixes a file waits for, e.g. .done"`
+	StableObserve   bool   `yaml:"stable_observe" flagName:"observe" flagSName:"so" flagDescribe:"Collect file only when unchanged since last observed"`
+	StableOpenCheck bool   `yaml:"stable_open_check" flagName:"opencheck" flagSName:"soc" flagDescribe:"Skip files opened for writing, linux only"`
+
 	SenderMaxWorkers int `yaml:"max_reader" flagName:"readers" flagSName:"rworker" flagDescribe:"Max worker for reading file" default:"500"`
 	ReaderMaxWorkers int `yaml:"max_sender" flagName:"senders" flagSName:"sworker" flagDescribe:"Max worker for sending file" default:"500"`
 
 	// max size in bytes that a file be filtered
 	FileMaxSize string `yaml:"file_limit" flagName:"limit" flagSName:"flimit" flagDescribe:"File size limit in human size" default:"200M"`
 
+	// file larger than chunk size is sent in chunks, memory budget bound
+	// the file bytes held in memory across all readers
+	ChunkSize    string `yaml:"chunk_size" flagName:"chunk" flagSName:"cs" flagDescribe:"Chunk size of large file in human size" default:"4M"`
+	MemoryBudget string `yaml:"memory_budget" flagName:"membudget" flagSName:"mb" flagDescribe:"Max file bytes in memory in human size" default:"1G"`
+
+	// compress codec and level of file content
+	CompressCodec string `yaml:"compress_codec" flagName:"codec" flagSName:"cc" flagDescribe:"Compress codec: none, zlib, gzip, flate, snappy, zstd" default:"zlib"`
+	CompressLevel int    `yaml:"compress_level" flagName:"clevel" flagSName:"cl" flagDescribe:"Compress level of codec, 1-9 for deflate codecs, 1-22 for zstd" default:"6"`
+
 	// do not delete file after sent
-	ReserveFile bool `yaml:"reserve_file" flagName:"reserve" flagSName:"keep" flagDescribe:"Keep file after sent" default:"false""`
+	ReserveFile bool `yaml:"reserve_file" flagName:"reserve" flagSName:"keep" flagDescribe:"Keep file after sent" default:"false"`
 
 	// cache time in second before delete file
 	FileCacheTimeout int `yaml:"cache_timeout" flagName:"ctime" flagSName:"ct" flagDescribe:"File Cache timeout" default:"3600"`
 
+	// directory to keep sent files, default to .sent inside collect directory
+	SentDirectory string `yaml:"sent_directory" flagName:"sdir" flagSName:"sd" flagDescribe:"Directory to keep sent files" default:""`
+
+	// directory to move files rejected by destination, default to .error inside collect directory
+	ErrorDirectory string `yaml:"error_directory" flagName:"edir" flagSName:"ed" flagDescribe:"Directory to move files rejected by destination" default:""`
+
+	// journal db to recover file lifecycle after crash, empty to disable
+	JournalFile string `yaml:"journal_file" flagName:"jfile" flagSName:"jf" flagDescribe:"Journal db file path" default:"journal.db"`
+
 	LogFileName string `yaml:"log_file" flagName:"lfile" flagSName:"log" flagDescribe:"File to write log" default:"sender.log"`
 
 	// file watch directory
 	CollectDirectory string `yaml:"collect_directory" flagName:"cdir" flagSName:"d" flagDescribe:"File collect directory" default:"/opt/files"`
+
+	// collect on inotify events instead of polling, with full walk in second as fallback
+	WatchMode         bool `yaml:"watch" flagName:"watch" flagSName:"w" flagDescribe:"Collect file on directory events" default:"false"`
+	WatchWalkInterval int  `yaml:"watch_walk_interval" flagName:"wwinterval" flagSName:"wwi" flagDescribe:"Full walk interval in second in watch mode" default:"300"`
+}
+
+// ClaimTTL return expiry of claim check messages, 0 for no expiry
+func (o *AppConfigOption) ClaimTTL() time.Duration {
+	switch {
+	case o.RedisClaimTTL < 0:
+		return 0
+	case o.RedisClaimTTL == 0:
+		return time.Duration(o.FileCacheTimeout) * time.Second
+	}
+	return time.Duration(o.RedisClaimTTL) * time.Second
+}
+
+// RedisOptions build redis client options of destination
+func (o *AppConfigOption) RedisOptions() (*redis.Options, error) {
+	tlsConfig, err := o.RedisTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	password, db, onConnect := o.redisAuth()
+
+	opts := &redis.Options{
+		Network:      "tcp",
+		Addr:         fmt.Sprintf("%s:%d", o.RedisHost, o.RedisPort),
+		DB:           db,
+		Password:     password,
+		OnConnect:    onConnect,
+		MaxRetries:   3,
+		PoolSize:     o.RedisPoolSize,
+		PoolTimeout:  millisecond(o.RedisPoolTimeout),
+		DialTimeout:  millisecond(o.RedisDialTimeout),
+		ReadTimeout:  millisecond(o.RedisReadTimeout),
+		WriteTimeout: millisecond(o.RedisWriteTimeout),
+		TLSConfig:    tlsConfig,
+	}
+	if strings.HasPrefix(o.RedisHost, unixPrefix) {
+		opts.Network = "unix"
+		opts.Addr = strings.TrimPrefix(o.RedisHost, unixPrefix)
+	}
+	return opts, nil
+}
+
+// RedisClient create redis client of destination by redis mode
+func (o *AppConfigOption) RedisClient() (redis.UniversalClient, error) {
+	password, db, onConnect := o.redisAuth()
+
+	switch o.RedisMode {
+	case "", RedisStandalone:
+		opts, err := o.RedisOptions()
+		if err != nil {
+			return nil, err
+		}
+		return redis.NewClient(opts), nil
+	case RedisSentinel:
+		addrs := splitList(o.RedisSentinels)
+		if o.RedisMasterName == "" || len(addrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode needs master name and sentinel addresses")
+		}
+		if o.RedisTLS {
+			return nil, fmt.Errorf("redis tls is only supported in standalone mode")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    o.RedisMasterName,
+			SentinelAddrs: addrs,
+			DB:            db,
+			Password:      password,
+			OnConnect:     onConnect,
+			MaxRetries:    3,
+			PoolSize:      o.RedisPoolSize,
+			PoolTimeout:   millisecond(o.RedisPoolTimeout),
+			DialTimeout:   millisecond(o.RedisDialTimeout),
+			ReadTimeout:   millisecond(o.RedisReadTimeout),
+			WriteTimeout:  millisecond(o.RedisWriteTimeout),
+		}), nil
+	case RedisCluster:
+		addrs := splitList(o.RedisClusterNodes)
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("cluster mode needs seed node addresses")
+		}
+		if o.RedisTLS {
+			return nil, fmt.Errorf("redis tls is only supported in standalone mode")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        addrs,
+			Password:     password,
+			OnConnect:    onConnect,
+			MaxRetries:   3,
+			PoolSize:     o.RedisPoolSize,
+			PoolTimeout:  millisecond(o.RedisPoolTimeout),
+			DialTimeout:  millisecond(o.RedisDialTimeout),
+			ReadTimeout:  millisecond(o.RedisReadTimeout),
+			WriteTimeout: millisecond(o.RedisWriteTimeout),
+		}), nil
+	}
+	return nil, fmt.Errorf("unknown redis mode: %s", o.RedisMode)
+}
+
+// RedisTLSConfig load tls config of destination, nil if tls is disabled
+func (o *AppConfigOption) RedisTLSConfig() (*tls.Config, error) {
+	if !o.RedisTLS {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         o.RedisTLSServerName,
+		InsecureSkipVerify: o.RedisTLSSkipVerify,
+	}
+	if config.ServerName == "" && !strings.HasPrefix(o.RedisHost, unixPrefix) {
+		config.ServerName = o.RedisHost
+	}
+
+	if o.RedisTLSCA != "" {
+		pem, err := ioutil.ReadFile(o.RedisTLSCA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", o.RedisTLSCA)
+		}
+	}
+
+	if o.RedisTLSCert != "" || o.RedisTLSKey != "" {
+		cert, err := tls.LoadX509KeyPair(o.RedisTLSCert, o.RedisTLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load redis client certificate error: %s", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// redisAuth return password and db for client options, with an ACL user
+// the client can not AUTH with password only, so AUTH and SELECT are
+// sent on connect instead
+func (o *AppConfigOption) redisAuth() (string, int, func(*redis.Conn) error) {
+	if o.RedisUsername == "" {
+		return o.RedisPW, o.RedisDB, nil
+	}
+
+	user, password, db := o.RedisUsername, o.RedisPW, o.RedisDB
+	return "", 0, func(conn *redis.Conn) error {
+		auth := redis.NewStatusCmd("auth", user, password)
+		conn.Process(auth)
+		if err := auth.Err(); err != nil {
+			return err
+		}
+		if db > 0 {
+			return conn.Select(db).Err()
+		}
+		return nil
+	}
+}
+
+// millisecond convert option in millisecond to duration
+func millisecond(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
+// splitList split comma separated list, blanks are dropped
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ReceiveConfigOption define receive command args
+type ReceiveConfigOption struct {
+	// directory to restore received files
+	OutputDirectory string `yaml:"receive_directory" flagName:"odir" flagSName:"o" flagDescribe:"Directory to restore received files" default:"/opt/received"`
+
+	ReceiveWorkers int `yaml:"receive_workers" flagName:"workers" flagSName:"rn" flagDescribe:"Max worker for receiving file" default:"8"`
+
+	// overwrite or skip when file exists
+	ReceivePolicy string `yaml:"receive_policy" flagName:"policy" flagSName:"p" flagDescribe:"Policy when file exists: overwrite, skip" default:"overwrite"`
+
+	// time in second to wait missing chunks of large file
+	ChunkTimeout int `yaml:"receive_chunk_timeout" flagName:"chtimeout" flagSName:"cht" flagDescribe:"Timeout in second to wait missing chunks" default:"3600"`
+
+	// consumer name owning messages being restored, host name if empty
+	ReceiveConsumer string `yaml:"receive_consumer" flagName:"consumer" flagSName:"rcon" flagDescribe:"Consumer name of receiver, host name if empty" default:""`
+
+	// consumer group reading stream backend
+	ReceiveGroup string `yaml:"receive_group" flagName:"group" flagSName:"rgrp" flagDescribe:"Consumer group reading stream backend" default:"colly"`
 }
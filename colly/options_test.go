@@ -0,0 +1,477 @@
+// Test Suit for options
+package colly
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+func TestAppConfigOption_RedisClient(t *testing.T) {
+	invalid := []AppConfigOption{
+		{RedisMode: "replica"},
+		{RedisMode: RedisSentinel, RedisSentinels: "127.0.0.1:26379"},
+		{RedisMode: RedisSentinel, RedisMasterName: "mymaster", RedisSentinels: " , "},
+		{RedisMode: RedisCluster},
+	}
+	for _, o := range invalid {
+		if _, err := o.RedisClient(); err == nil {
+			t.Errorf("redis mode %q should be rejected: %+v", o.RedisMode, o)
+		}
+	}
+
+	if addrs := splitList(" a:1, ,b:2,"); len(addrs) != 2 || addrs[0] != "a:1" || addrs[1] != "b:2" {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+}
+
+func TestAppConfigOption_RedisClient_Sentinel(t *testing.T) {
+	sentinel, err := fakeSentinel("mymaster", opts.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sentinel.Close()
+
+	o := AppConfigOption{
+		RedisMode:       RedisSentinel,
+		RedisMasterName: "mymaster",
+		RedisSentinels:  sentinel.Addr().String(),
+	}
+	client, err := o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := DestQueueName + ":sentinel"
+	inst, errs := NewRedisWriterWithClient(client, queue, 10)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	defer inst.Close()
+	inst.Client.Del(queue)
+	defer inst.Client.Del(queue)
+
+	if err := inst.SendFileContent("x"); err != nil {
+		t.Fatal(err)
+	}
+	if inst.GetDestQueueSize() != 1 {
+		t.Errorf("push through sentinel master failed, size: %d", inst.GetDestQueueSize())
+	}
+}
+
+func TestAppConfigOption_RedisClient_Cluster(t *testing.T) {
+	backend := redis.NewClient(opts)
+	defer backend.Close()
+	node, err := fakeClusterNode(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	o := AppConfigOption{
+		RedisMode:         RedisCluster,
+		RedisClusterNodes: node.Addr().String(),
+	}
+	client, err := o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := DestQueueName + ":cluster"
+	inst, errs := NewRedisWriterWithClient(client, queue, 10)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	defer inst.Close()
+	inst.Client.Del(queue)
+	defer inst.Client.Del(queue)
+
+	results := inst.SendBatch([]string{"a", "b"})
+	for i, err := range results {
+		if err != nil {
+			t.Errorf("message %d should be pushed: %v", i, err)
+		}
+	}
+	if inst.GetDestQueueSize() != 2 {
+		t.Errorf("push through cluster failed, size: %d", inst.GetDestQueueSize())
+	}
+}
+
+func TestAppConfigOption_RedisClient_Unix(t *testing.T) {
+	backend := redis.NewClient(opts)
+	defer backend.Close()
+	if err := backend.Ping().Err(); err != nil {
+		t.Skip(err.Error())
+	}
+
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "redis.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip(err.Error())
+	}
+	defer l.Close()
+	standIn(l, backend, func(args []string, self string) (interface{}, bool) { return nil, false })
+
+	o := AppConfigOption{RedisHost: "unix://" + sock, RedisPort: 6379}
+	ropts, err := o.RedisOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ropts.Network != "unix" || ropts.Addr != sock {
+		t.Errorf("unexpected unix options: %s %s", ropts.Network, ropts.Addr)
+	}
+	client, err := o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAppConfigOption_RedisClient_ACL(t *testing.T) {
+	backend := redis.NewClient(opts)
+	defer backend.Close()
+	if err := backend.Ping().Err(); err != nil {
+		t.Skip(err.Error())
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// select is answered here, a pooled backend can not keep per
+	// connection db
+	var authed, selected int32
+	standIn(l, backend, func(args []string, self string) (interface{}, bool) {
+		if strings.ToLower(args[0]) != "auth" {
+			if atomic.LoadInt32(&authed) == 0 {
+				return fmt.Errorf("NOAUTH Authentication required"), true
+			}
+			if strings.ToLower(args[0]) == "select" {
+				db, _ := strconv.Atoi(args[1])
+				atomic.StoreInt32(&selected, int32(db))
+				return "OK", true
+			}
+			return nil, false
+		}
+		if len(args) == 3 && args[1] == "alice" && args[2] == "secret" {
+			atomic.StoreInt32(&authed, 1)
+			return "OK", true
+		}
+		return fmt.Errorf("WRONGPASS invalid username-password pair"), true
+	})
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	o := AppConfigOption{RedisHost: host, RedisPort: p, RedisDB: 1, RedisUsername: "alice", RedisPW: "secret"}
+	client, err := o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	key := DestQueueName + ":acl"
+	if err := client.Set(key, "x", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Del(key)
+	if atomic.LoadInt32(&selected) != 1 {
+		t.Error("db should be selected after AUTH")
+	}
+
+	o.RedisPW = "wrong"
+	client, err = o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Ping().Err(); err == nil {
+		t.Error("wrong ACL password should be rejected")
+	}
+}
+
+func TestAppConfigOption_RedisTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile, err := selfSignedCert(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o := AppConfigOption{RedisHost: "127.0.0.1", RedisTLS: true, RedisTLSCA: keyFile}
+	if _, err := o.RedisTLSConfig(); err == nil {
+		t.Error("CA bundle without certificate should be rejected")
+	}
+	o = AppConfigOption{RedisTLS: true, RedisMode: RedisCluster, RedisClusterNodes: "127.0.0.1:7000"}
+	if _, err := o.RedisClient(); err == nil {
+		t.Error("tls should be rejected in cluster mode")
+	}
+
+	backend := redis.NewClient(opts)
+	defer backend.Close()
+	if err := backend.Ping().Err(); err != nil {
+		t.Skip(err.Error())
+	}
+
+	// server trust the same self signed certificate as client certificate
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pem, _ := ioutil.ReadFile(certFile)
+	pool.AppendCertsFromPEM(pem)
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	standIn(l, backend, func(args []string, self string) (interface{}, bool) { return nil, false })
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	o = AppConfigOption{
+		RedisHost:          host,
+		RedisPort:          p,
+		RedisTLS:           true,
+		RedisTLSCA:         certFile,
+		RedisTLSCert:       certFile,
+		RedisTLSKey:        keyFile,
+		RedisTLSServerName: "localhost",
+	}
+	client, err := o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Ping().Err(); err != nil {
+		t.Error(err)
+	}
+
+	o.RedisTLSServerName = "redis.example.com"
+	client, err = o.RedisClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Ping().Err(); err == nil {
+		t.Error("server name mismatch should fail verify")
+	}
+}
+
+// selfSignedCert write a certificate for localhost and its key in dir
+func selfSignedCert(dir string) (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return "", "", err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+// fakeSentinel answer the sentinel commands go-redis needs to find the
+// master of name
+func fakeSentinel(name string, master string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(master)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	standIn(l, nil, func(args []string, self string) (interface{}, bool) {
+		switch strings.ToLower(strings.Join(args, " ")) {
+		case "ping":
+			return "PONG", true
+		case "sentinel get-master-addr-by-name " + strings.ToLower(name):
+			return []interface{}{host, port}, true
+		case "sentinel sentinels " + strings.ToLower(name):
+			return []interface{}{}, true
+		case "subscribe +switch-master":
+			return []interface{}{"subscribe", args[1], int64(1)}, true
+		}
+		return nil, false
+	})
+	return l, nil
+}
+
+// fakeClusterNode act as a cluster of one node owning all slots, data
+// commands are forwarded to backend
+func fakeClusterNode(backend *redis.Client) (net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	standIn(l, backend, func(args []string, self string) (interface{}, bool) {
+		switch strings.ToLower(strings.Join(args, " ")) {
+		case "cluster info":
+			return "cluster_state:ok", true
+		case "cluster slots":
+			host, port, _ := net.SplitHostPort(self)
+			p, _ := strconv.ParseInt(port, 10, 64)
+			return []interface{}{[]interface{}{int64(0), int64(16383), []interface{}{host, p}}}, true
+		}
+		return nil, false
+	})
+	return l, nil
+}
+
+// standIn serve redis protocol on listener, commands not handled are
+// forwarded to backend or rejected if there is no backend
+func standIn(l net.Listener, backend *redis.Client, handle func(args []string, self string) (interface{}, bool)) {
+	self := l.Addr().String()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					reply, ok := handle(args, self)
+					if !ok && backend != nil {
+						cmdArgs := make([]interface{}, len(args))
+						for i, arg := range args {
+							cmdArgs[i] = arg
+						}
+						cmd := redis.NewCmd(cmdArgs...)
+						backend.Process(cmd)
+						if reply, err = cmd.Result(); err != nil && err != redis.Nil {
+							reply = err
+						}
+						ok = true
+					}
+					if !ok {
+						reply = fmt.Errorf("ERR unknown command")
+					}
+					conn.Write(writeReply(nil, reply))
+				}
+			}()
+		}
+	}()
+}
+
+// readCommand read one RESP array of bulk strings
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(buf []byte, reply interface{}) []byte {
+	switch v := reply.(type) {
+	case nil:
+		return append(buf, "$-1\r\n"...)
+	case error:
+		return append(buf, "-"+v.Error()+"\r\n"...)
+	case int64:
+		return append(buf, fmt.Sprintf(":%d\r\n", v)...)
+	case string:
+		return append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)...)
+	case []interface{}:
+		buf = append(buf, fmt.Sprintf("*%d\r\n", len(v))...)
+		for _, item := range v {
+			buf = writeReply(buf, item)
+		}
+		return buf
+	}
+	return append(buf, fmt.Sprintf("-ERR unexpected reply %T\r\n", reply)...)
+}
+
+func TestAppConfigOption_ClaimTTL(t *testing.T) {
+	cases := []struct {
+		ttl, cache int
+		expect     time.Duration
+	}{
+		{0, 3600, time.Hour},
+		{60, 3600, time.Minute},
+		{-1, 3600, 0},
+	}
+	for _, c := range cases {
+		opts := &AppConfigOption{RedisClaimTTL: c.ttl, FileCacheTimeout: c.cache}
+		if ttl := opts.ClaimTTL(); ttl != c.expect {
+			t.Errorf("claim ttl %d with cache timeout %d: expect %s, got %s", c.ttl, c.cache, c.expect, ttl)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// Ordering of files found in one walk pass
+package colly
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// orders of files in a walk pass
+const (
+	// walk order, files in a directory by name
+	OrderLexical = "lexical"
+	// oldest modified first
+	OrderMtime = "mtime"
+	// smallest first
+	OrderSize   = "size"
+	OrderRandom = "random"
+)
+
+// CheckOrder check if walk order is known
+func CheckOrder(order string) error {
+	switch order {
+	case "", OrderLexical, OrderMtime, OrderSize, OrderRandom:
+		return nil
+	}
+	return fmt.Errorf("unknown walk order: %s", order)
+}
+
+// itemHeap is a min heap of file items
+type itemHeap struct {
+	items []FileItem
+	less  func(a, b *FileItem) bool
+}
+
+func (h *itemHeap) Len() int           { return len(h.items) }
+func (h *itemHeap) Less(i, j int) bool { return h.less(&h.items[i], &h.items[j]) }
+func (h *itemHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *itemHeap) Push(x interface{}) { h.items = append(h.items, x.(FileItem)) }
+func (h *itemHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// orderLess return compare function of order, ties are broken by path
+// so the order is stable between passes
+func orderLess(order string) func(a, b *FileItem) bool {
+	switch order {
+	case OrderMtime:
+		return func(a, b *FileItem) bool {
+			if a.ModTime != b.ModTime {
+				return a.ModTime < b.ModTime
+			}
+			return a.FilePath < b.FilePath
+		}
+	case OrderSize:
+		return func(a, b *FileItem) bool {
+			if a.FileSize != b.FileSize {
+				return a.FileSize < b.FileSize
+			}
+			return a.FilePath < b.FilePath
+		}
+	}
+	return nil
+}
+
+// orderItems reorder walked items within a window of at most window
+// items, so memory stays bounded on large trees. once the window is full
+// the first item by order leaves for each item coming in, the order is
+// exact when the pass fits in the window
+func (w *FileWalker) orderItems(items <-chan FileItem) <-chan FileItem {
+	if w.Order == "" || w.Order == OrderLexical {
+		return items
+	}
+	window := w.OrderWindow
+	if window < 1 {
+		window = 1
+	}
+
+	ordered := make(chan FileItem)
+	go func() {
+		defer close(ordered)
+
+		var take func() FileItem
+		var put func(item FileItem)
+		var size func() int
+		if w.Order == OrderRandom {
+			random := rand.New(rand.NewSource(time.Now().UnixNano()))
+			var pool []FileItem
+			put = func(item FileItem) { pool = append(pool, item) }
+			take = func() FileItem {
+				i := random.Intn(len(pool))
+				item := pool[i]
+				pool[i] = pool[len(pool)-1]
+				pool = pool[:len(pool)-1]
+				return item
+			}
+			size = func() int { return len(pool) }
+		} else {
+			h := &itemHeap{less: orderLess(w.Order)}
+			put = func(item FileItem) { heap.Push(h, item) }
+			take = func() FileItem { return heap.Pop(h).(FileItem) }
+			size = h.Len
+		}
+
+		emit := func() bool {
+			select {
+			case ordered <- take():
+				return true
+			case <-w.Ctx.Done():
+				return false
+			}
+		}
+
+		for item := range items {
+			put(item)
+			if size() >= window && !emit() {
+				break
+			}
+		}
+		for size() > 0 && emit() {
+		}
+		// let the walk goroutine finish on cancel
+		for range items {
+		}
+	}()
+	return ordered
+}
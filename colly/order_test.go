@@ -0,0 +1,104 @@
+// Test Suit for walk order
+package colly
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func orderedNames(t *testing.T, w *FileWalker) []string {
+	items, errc := w.Walk()
+	var names []string
+	for item := range items {
+		names = append(names, filepath.Base(item.FilePath))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestFileWalker_Order(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// name, size and age in hours
+	files := []struct {
+		name string
+		size int
+		age  int
+	}{
+		{"a", 30, 1}, {"b", 10, 5}, {"c", 50, 2}, {"d", 20, 4}, {"e", 40, 3},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		ioutil.WriteFile(path, make([]byte, f.size), 0644)
+		mtime := time.Now().Add(-time.Duration(f.age) * time.Hour)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	cases := []struct {
+		order  string
+		window int
+		expect string
+	}{
+		{OrderLexical, 0, "abcde"},
+		{OrderMtime, 100, "bdeca"},
+		{OrderSize, 100, "bdaec"},
+		// window of 2 only reorders neighbours
+		{OrderMtime, 2, "bcdea"},
+	}
+	for _, cs := range cases {
+		w := NewDirectoryWorker(dir, 1, Rule{}, context.Background())
+		w.Order, w.OrderWindow = cs.order, cs.window
+		if names := strings.Join(orderedNames(t, w), ""); names != cs.expect {
+			t.Errorf("order %s window %d: expect %s, got %s", cs.order, cs.window, cs.expect, names)
+		}
+	}
+
+	w := NewDirectoryWorker(dir, 1, Rule{}, context.Background())
+	w.Order, w.OrderWindow = OrderRandom, 3
+	names := orderedNames(t, w)
+	sort.Strings(names)
+	if strings.Join(names, "") != "abcde" {
+		t.Errorf("random order should keep every file: %v", names)
+	}
+
+	if CheckOrder("newest") == nil {
+		t.Error("unknown order should be rejected")
+	}
+}
+
+func TestFileWalker_OrderCanceled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a", "b", "c"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := NewDirectoryWorker(dir, 1, Rule{}, ctx)
+	w.Order, w.OrderWindow = OrderSize, 100
+	items, errc := w.Walk()
+	cancel()
+
+	select {
+	case <-errc:
+	case <-time.After(time.Second):
+		t.Fatal("walk should stop on cancel")
+	}
+	for range items {
+	}
+}
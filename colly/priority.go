@@ -0,0 +1,182 @@
+// Priority classes of files and weighted lanes between them
+package colly
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultClass is the name of class of files matching no priority
+const DefaultClass = "default"
+
+// Priority is a class of files with its own sending lane, the first class
+// whose selector matches wins. a class may have its own queue or queue
+// limit on every destination, e.g. a lower limit for bulk files keeps
+// room in a shared queue for urgent ones
+type Priority struct {
+	Selector `yaml:",inline"`
+
+	Name string `yaml:"name"`
+	// share of sends when lanes are busy, default 1
+	Weight int `yaml:"weight"`
+
+	// override dest_queue and dest_queue_limit of destinations
+	Queue      string `yaml:"queue"`
+	QueueLimit int    `yaml:"queue_limit"`
+}
+
+// init check the class and parse its conditions
+func (p *Priority) init() error {
+	if err := p.Selector.init(); err != nil {
+		return err
+	}
+	if p.Name == "" || p.Name == DefaultClass || strings.Contains(p.Name, "/") {
+		return fmt.Errorf("invalid priority name: %q", p.Name)
+	}
+	if p.Weight < 0 || p.QueueLimit < 0 {
+		return fmt.Errorf("negative weight or queue limit of priority %s", p.Name)
+	}
+	if p.Weight == 0 {
+		p.Weight = 1
+	}
+	return nil
+}
+
+// laneName is the name of destination serving class
+func laneName(destination string, class string) string {
+	return destination + "/" + class
+}
+
+// lane is the files of one class waiting to be sent, and the slots
+// reserved by readers encoding files of the class
+type lane struct {
+	items    []EncodeResult
+	reserved int
+	weight   int
+	current  int
+}
+
+// Lanes hold encoded files by class until senders take them, senders take
+// from busy lanes in smooth weighted round robin so a class gets its
+// weight share of sends and bulk files can not starve urgent ones. readers
+// reserve a slot before encoding a file, a full lane turns its files away
+// instead of holding the readers shared by all classes
+type Lanes struct {
+	sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	lanes []lane
+	// max files waiting in one lane
+	capacity int
+	closed   bool
+}
+
+// NewLanes create lanes of weights, each holding up to capacity files
+func NewLanes(weights []int, capacity int) *Lanes {
+	if capacity < 1 {
+		capacity = 1
+	}
+	l := &Lanes{lanes: make([]lane, len(weights)), capacity: capacity}
+	for i, weight := range weights {
+		l.lanes[i].weight = weight
+	}
+	l.notEmpty = sync.NewCond(&l.Mutex)
+	l.notFull = sync.NewCond(&l.Mutex)
+	return l
+}
+
+// Reserve take a slot in lane of class for a file about to be encoded,
+// false if the lane is full or lanes are closed. a lone lane has no other
+// class to let through, it blocks while full instead
+func (l *Lanes) Reserve(class int) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	ln := &l.lanes[class]
+	for !l.closed && len(ln.items)+ln.reserved >= l.capacity {
+		if len(l.lanes) > 1 {
+			return false
+		}
+		l.notFull.Wait()
+	}
+	if l.closed {
+		return false
+	}
+	ln.reserved++
+	return true
+}
+
+// Push add file to lane of class taking its reserved slot, it never
+// blocks and returns false if lanes are closed
+func (l *Lanes) Push(class int, r EncodeResult) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	ln := &l.lanes[class]
+	if ln.reserved > 0 {
+		ln.reserved--
+	}
+	if l.closed {
+		return false
+	}
+	ln.items = append(ln.items, r)
+	l.notEmpty.Signal()
+	return true
+}
+
+// Pop take next file, it blocks while lanes are empty and returns false
+// once lanes are closed and drained
+func (l *Lanes) Pop() (EncodeResult, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	for {
+		if next := l.next(); next != nil {
+			r := next.items[0]
+			next.items[0] = EncodeResult{}
+			next.items = next.items[1:]
+			if len(next.items) == 0 {
+				next.current = 0
+			}
+			l.notFull.Broadcast()
+			return r, true
+		}
+		if l.closed {
+			return EncodeResult{}, false
+		}
+		l.notEmpty.Wait()
+	}
+}
+
+// Close wake up blocked pushes and pops, files left are still popped
+func (l *Lanes) Close() {
+	l.Lock()
+	l.closed = true
+	l.Unlock()
+	l.notEmpty.Broadcast()
+	l.notFull.Broadcast()
+}
+
+// next pick lane by smooth weighted round robin among non-empty lanes,
+// caller must hold the lock
+func (l *Lanes) next() *lane {
+	var picked *lane
+	total := 0
+	for i := range l.lanes {
+		ln := &l.lanes[i]
+		if len(ln.items) == 0 {
+			continue
+		}
+		ln.current += ln.weight
+		total += ln.weight
+		if picked == nil || ln.current > picked.current {
+			picked = ln
+		}
+	}
+	if picked != nil {
+		picked.current -= total
+	}
+	return picked
+}
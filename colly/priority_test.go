@@ -0,0 +1,322 @@
+// Test Suit for priority lanes
+package colly
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smileboywtu/FileColly/common"
+	"gopkg.in/yaml.v2"
+)
+
+func TestLanes_Weighted(t *testing.T) {
+	lanes := NewLanes([]int{3, 1}, 10)
+	for i := 0; i < 8; i++ {
+		lanes.Push(1, EncodeResult{Path: "bulk"})
+	}
+	for i := 0; i < 6; i++ {
+		lanes.Push(0, EncodeResult{Path: "urgent"})
+	}
+	lanes.Close()
+
+	var order []string
+	for {
+		r, ok := lanes.Pop()
+		if !ok {
+			break
+		}
+		order = append(order, r.Path)
+	}
+	if len(order) != 14 {
+		t.Fatalf("all files should be popped after close: %v", order)
+	}
+	urgent := 0
+	for _, path := range order[:8] {
+		if path == "urgent" {
+			urgent++
+		}
+	}
+	if urgent != 6 || order[0] != "urgent" {
+		t.Errorf("urgent lane should get 3 of 4 sends: %v", order)
+	}
+	if lanes.Push(0, EncodeResult{}) {
+		t.Error("push should fail after close")
+	}
+}
+
+func TestLanes_Capacity(t *testing.T) {
+	lanes := NewLanes([]int{1, 1}, 2)
+	if !lanes.Reserve(0) || !lanes.Reserve(0) {
+		t.Fatal("lane should have room")
+	}
+	// a full lane turns files away, other lane is not blocked by it
+	if lanes.Reserve(0) {
+		t.Error("reserve should fail while lane is full")
+	}
+	if !lanes.Reserve(1) || !lanes.Push(1, EncodeResult{Path: "c"}) {
+		t.Fatal("push to other lane should not block")
+	}
+	lanes.Push(0, EncodeResult{Path: "a"})
+	lanes.Push(0, EncodeResult{Path: "b"})
+	if lanes.Reserve(0) {
+		t.Error("reserve should fail while lane is full")
+	}
+	lanes.Pop()
+	lanes.Pop()
+	if !lanes.Reserve(0) {
+		t.Error("reserve should go on once lane has room")
+	}
+
+	// a lone lane waits for room
+	lone := NewLanes([]int{1}, 1)
+	lone.Reserve(0)
+	lone.Push(0, EncodeResult{Path: "a"})
+	reserved := make(chan bool)
+	go func() {
+		reserved <- lone.Reserve(0)
+	}()
+	select {
+	case <-reserved:
+		t.Fatal("reserve should block while lone lane is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	lone.Pop()
+	if !<-reserved {
+		t.Error("reserve should go on once lane has room")
+	}
+	lone.Close()
+	if lone.Reserve(0) || lone.Push(0, EncodeResult{}) {
+		t.Error("reserve and push should fail after close")
+	}
+}
+
+func TestNewRouter_Priorities(t *testing.T) {
+	var opts AppConfigOption
+	err := yaml.Unmarshal([]byte(`
+dest_queue: files
+dest_queue_limit: 100
+destinations:
+  archive:
+    dest_queue: archive
+priorities:
+  - name: alert
+    ext: alert
+    weight: 8
+    queue: alerts
+  - name: bulk
+    min_size: 1M
+    queue_limit: 80
+`), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter(&opts, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if weights := router.Weights(); len(weights) != 3 || weights[0] != 8 || weights[1] != 1 || weights[2] != 1 {
+		t.Errorf("unexpected weights: %v", weights)
+	}
+
+	alert := router.Class(FileItem{FileIndex: "/a.alert", FileSize: 2 << 20})
+	bulk := router.Class(FileItem{FileIndex: "/a.log", FileSize: 2 << 20})
+	other := router.Class(FileItem{FileIndex: "/a.log", FileSize: 10})
+	if alert != 0 || bulk != 1 || other != 2 {
+		t.Fatalf("unexpected classes: %d, %d, %d", alert, bulk, other)
+	}
+	if dest := router.Destination("archive", alert); dest.Name != "archive/alert" {
+		t.Errorf("alert should go through its lane: %s", dest.Name)
+	}
+	if dest := router.Destination(DefaultDestination, other); dest != router.Destinations[DefaultDestination] {
+		t.Errorf("default class should use destination: %s", dest.Name)
+	}
+	if len(router.lanes) != 4 {
+		t.Errorf("expect 4 lane destinations, got %d", len(router.lanes))
+	}
+
+	invalid := []string{
+		"priorities: [{ext: log}]",
+		"priorities: [{name: default}]",
+		"priorities: [{name: a/b}]",
+		"priorities: [{name: a}, {name: a}]",
+		"priorities: [{name: a, weight: -1}]",
+		"priorities: [{name: a, glob: '['}]",
+		"{backend: spool, priorities: [{name: a, queue: q}]}",
+	}
+	for _, conf := range invalid {
+		var opts AppConfigOption
+		if err := yaml.Unmarshal([]byte(conf), &opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewRouter(&opts, RetryPolicy{}); err == nil {
+			t.Errorf("config should be rejected: %s", conf)
+		}
+	}
+}
+
+func TestCollector_StartPriorities(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collect := filepath.Join(dir, "files")
+	os.MkdirAll(collect, 0755)
+	names := []string{"a.txt", "b.txt", "c.txt", "d.alert", "e.alert"}
+	for _, name := range names {
+		ioutil.WriteFile(filepath.Join(collect, name), []byte(name), 0644)
+	}
+
+	opts := &AppConfigOption{}
+	if err := common.ApplyDefaultValues(opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Backend = BackendSpool
+	opts.SpoolDirectory = filepath.Join(dir, "spool")
+	opts.DestinationRedisQueueLimit = -1
+	opts.Priorities = []Priority{{Name: "bulk", Selector: Selector{Ext: "txt"}, QueueLimit: 2}}
+	opts.SendRetries = 0
+	opts.CollectDirectory = collect
+	opts.JournalFile = ""
+	opts.LogFileName = filepath.Join(os.TempDir(), "colly_test.log")
+	opts.ReaderMaxWorkers, opts.SenderMaxWorkers = 2, 2
+
+	c, err := NewCollector(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+
+	// bulk files stop at their queue limit, alert files still go
+	left := 0
+	for _, name := range names {
+		_, err := os.Stat(filepath.Join(collect, name))
+		switch {
+		case filepath.Ext(name) == ".alert" && err == nil:
+			t.Errorf("alert file %s should be sent", name)
+		case filepath.Ext(name) == ".txt" && err == nil:
+			left++
+		}
+	}
+	if left == 0 {
+		t.Error("bulk files over queue limit should be kept")
+	}
+}
+
+func TestDestination_Lane(t *testing.T) {
+	opts := &AppConfigOption{}
+	if err := common.ApplyDefaultValues(opts); err != nil {
+		t.Fatal(err)
+	}
+	parent := NewDestination(DefaultDestination, opts, RetryPolicy{})
+	lane := parent.Lane("default/bulk", "bulk", 5)
+	if _, ok := lane.Ready(); !ok {
+		t.Skip("redis is unavailable")
+	}
+	defer parent.Close()
+	defer lane.Close()
+
+	w, ok := lane.backend.(*RedisWriter)
+	if !ok || w.DestQueueName != "bulk" || w.QueueSizeLimit != 5 {
+		t.Fatalf("lane should push to its own queue: %+v", lane.backend)
+	}
+	if w.Client != parent.backend.(*RedisWriter).Client {
+		t.Error("lane should share the client of its destination")
+	}
+	lane.Close()
+	if err := parent.backend.(*RedisWriter).Client.Ping().Err(); err != nil {
+		t.Errorf("closing lane should keep the shared client: %v", err)
+	}
+}
+
+func TestCollector_SendPollClosed(t *testing.T) {
+	router, err := NewRouter(&AppConfigOption{}, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a budget not released times out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c := &Collector{
+		Router:   router,
+		budget:   NewMemoryBudget(ctx, 10),
+		inflight: make(map[string]struct{}),
+	}
+	budget, _ := c.budget.Acquire(10)
+	c.claim("a")
+
+	lanes := NewLanes(router.Weights(), 1)
+	lanes.Close()
+	c.sendPoll(lanes, EncodeResult{Path: "a", budget: budget})
+	if !c.claim("a") {
+		t.Error("file not queued should be unclaimed")
+	}
+	if _, ok := c.budget.Acquire(10); !ok {
+		t.Error("budget of file not queued should be released")
+	}
+}
+
+func TestCollector_EncodeFlowLaneFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"a.txt", "b.txt", "c.txt", "d.alert"}
+	for _, name := range names {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+	router, err := NewRouter(&AppConfigOption{Priorities: []Priority{{Name: "alert", Selector: Selector{Ext: "alert"}}}}, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newTestCollector(0)
+	c.UserConfigs = &AppConfigOption{CompressCodec: "none"}
+	c.Router = router
+	c.budget = NewMemoryBudget(context.Background(), 100)
+	c.inflight = make(map[string]struct{})
+
+	items := make(chan FileItem, len(names))
+	for _, name := range names {
+		items <- FileItem{FilePath: filepath.Join(dir, name), FileIndex: "/" + name}
+	}
+	close(items)
+
+	// no sender takes files, the default lane fills up and the alert file
+	// still gets through
+	lanes := NewLanes(router.Weights(), 1)
+	done := make(chan struct{})
+	go func() {
+		c.encodeFlow(items, lanes)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("readers should not block on a full lane")
+	}
+	lanes.Close()
+
+	var sent []string
+	for {
+		r, ok := lanes.Pop()
+		if !ok {
+			break
+		}
+		sent = append(sent, r.Item.FileIndex)
+	}
+	if len(sent) != 2 || sent[0] != "/d.alert" && sent[1] != "/d.alert" {
+		t.Errorf("alert file should be queued beside one default file: %v", sent)
+	}
+	if !c.claim(filepath.Join(dir, "c.txt")) {
+		t.Error("file turned away should be unclaimed")
+	}
+}
@@ -0,0 +1,159 @@
+// Keep sent files for a replay window and purge them after timeout
+package colly
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// default sent area name inside the collect directory
+const DefaultSentDirectory = ".sent"
+
+// default area of files rejected by destination inside collect directory
+const DefaultErrorDirectory = ".error"
+
+type RetentionManager struct {
+	sync.Mutex
+
+	// collect directory the files come from
+	Directory string
+	// directory to keep sent files
+	SentDirectory string
+	// directory to move files rejected by destination
+	ErrorDirectory string
+	// keep the file after sent or just remove it
+	Reserve bool
+	// how long a sent file is kept
+	Timeout time.Duration
+	// min interval between two purge pass
+	PurgeInterval time.Duration
+
+	lastPurge time.Time
+}
+
+// NewRetentionManager create retention manager, sent files are kept in sentDir
+// if reserve is true, otherwise they are removed right after sent
+func NewRetentionManager(directory string, sentDir string, reserve bool, timeout time.Duration) *RetentionManager {
+	if sentDir == "" {
+		sentDir = filepath.Join(directory, DefaultSentDirectory)
+	}
+	return &RetentionManager{
+		Directory:      directory,
+		SentDirectory:  sentDir,
+		ErrorDirectory: filepath.Join(directory, DefaultErrorDirectory),
+		Reserve:        reserve,
+		Timeout:        timeout,
+		PurgeInterval:  time.Minute,
+	}
+}
+
+// Release handle source file after it is delivered, the file is moved
+// into sent area with relative path kept in reserve mode
+func (m *RetentionManager) Release(path string) error {
+	if !m.Reserve {
+		return os.Remove(path)
+	}
+
+	dest := filepath.Join(m.SentDirectory, strings.TrimPrefix(path, m.Directory))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	if err := moveFile(path, dest); err != nil {
+		return err
+	}
+
+	// mtime of file in sent area is the sent time
+	now := time.Now()
+	return os.Chtimes(dest, now, now)
+}
+
+// Reject move file refused by destination into error area with relative
+// path kept, it is never sent again nor purged
+func (m *RetentionManager) Reject(path string) error {
+	dest := filepath.Join(m.ErrorDirectory, strings.TrimPrefix(path, m.Directory))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	return moveFile(path, dest)
+}
+
+// Purge remove sent files kept longer than timeout, return the number of
+// files removed. Purge do nothing if called again within PurgeInterval
+func (m *RetentionManager) Purge() (int, error) {
+	if !m.Reserve {
+		return 0, nil
+	}
+
+	m.Lock()
+	if time.Since(m.lastPurge) < m.PurgeInterval {
+		m.Unlock()
+		return 0, nil
+	}
+	m.lastPurge = time.Now()
+	m.Unlock()
+
+	if _, err := os.Stat(m.SentDirectory); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	removed := 0
+	dirs := make([]string, 0, 8)
+	deadline := time.Now().Add(-m.Timeout)
+	err := filepath.Walk(m.SentDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != m.SentDirectory {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if info.ModTime().Before(deadline) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+
+	// remove empty directories from the deepest one
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+
+	return removed, err
+}
+
+// moveFile rename file and fall back to copy when crossing file system
+func moveFile(src string, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dest)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
@@ -0,0 +1,78 @@
+// Test Suit for sent file retention
+package colly
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRetentionManager_ReleaseAndPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "sub", "a.txt")
+	os.MkdirAll(filepath.Dir(src), 0755)
+	ioutil.WriteFile(src, []byte("hello"), 0644)
+
+	m := NewRetentionManager(dir, "", true, time.Hour)
+	if err := m.Release(src); err != nil {
+		t.Fatal(err)
+	}
+
+	kept := filepath.Join(dir, DefaultSentDirectory, "sub", "a.txt")
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("source file should be moved")
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Fatal("sent file should be kept: ", err)
+	}
+
+	// not expired yet
+	if n, _ := m.Purge(); n != 0 {
+		t.Errorf("expect nothing purged, got %d", n)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(kept, old, old)
+	m.lastPurge = time.Time{}
+	if n, err := m.Purge(); err != nil || n != 1 {
+		t.Errorf("expect 1 file purged, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Dir(kept)); !os.IsNotExist(err) {
+		t.Error("empty directory should be purged")
+	}
+}
+
+func TestFileWalker_SkipSentDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.MkdirAll(filepath.Join(dir, DefaultSentDirectory), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, DefaultSentDirectory, "b.txt"), []byte("b"), 0644)
+
+	w := NewDirectoryWorker(dir, 1, Rule{}, context.Background())
+	w.SkipDir(filepath.Join(dir, DefaultSentDirectory))
+
+	files, errc := w.Walk()
+	var found []string
+	for item := range files {
+		found = append(found, item.FileIndex)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0] != "/a.txt" {
+		t.Errorf("unexpected walk result: %v", found)
+	}
+}
@@ -0,0 +1,310 @@
+// Route files to named destinations
+package colly
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/smileboywtu/FileColly/common"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultDestination is the name of destination set by top level options
+const DefaultDestination = "default"
+
+// fan-out modes of a route
+const (
+	// file is released when all destinations acknowledged
+	FanoutAll = "all"
+	// file is released when any destination acknowledged
+	FanoutAny = "any"
+)
+
+// Selector match files by conditions, a file matches when all conditions
+// set match
+type Selector struct {
+	// glob on file name, or on relative path if it has a slash
+	Glob string `yaml:"glob"`
+	// comma separated extensions, case insensitive
+	Ext string `yaml:"ext"`
+	// subdirectory relative to collect directory
+	Dir string `yaml:"dir"`
+	// file size range in human size
+	MinSize string `yaml:"min_size"`
+	MaxSize string `yaml:"max_size"`
+
+	minSize, maxSize int64
+	exts             []string
+}
+
+// Match check if file item matches selector
+func (s *Selector) Match(item FileItem) bool {
+	rel := strings.TrimPrefix(filepath.ToSlash(item.FileIndex), "/")
+
+	if s.Glob != "" && !matchGlob(s.Glob, rel) {
+		return false
+	}
+	if len(s.exts) > 0 {
+		ext := strings.ToLower(path.Ext(rel))
+		found := false
+		for _, e := range s.exts {
+			found = found || ext == e
+		}
+		if !found {
+			return false
+		}
+	}
+	if s.Dir != "" {
+		dir := strings.Trim(filepath.ToSlash(s.Dir), "/")
+		if !strings.HasPrefix(rel, dir+"/") {
+			return false
+		}
+	}
+	if s.minSize > 0 && item.FileSize < s.minSize {
+		return false
+	}
+	if s.maxSize > 0 && item.FileSize > s.maxSize {
+		return false
+	}
+	return true
+}
+
+// matchGlob match glob on base name of slash separated relative path, or
+// on the whole path if glob has a slash
+func matchGlob(glob string, rel string) bool {
+	name := path.Base(rel)
+	if strings.Contains(glob, "/") {
+		name = rel
+	}
+	ok, _ := path.Match(glob, name)
+	return ok
+}
+
+// init check the conditions and parse them
+func (s *Selector) init() error {
+	if s.Glob != "" {
+		if _, err := path.Match(s.Glob, ""); err != nil {
+			return fmt.Errorf("invalid glob: %s", s.Glob)
+		}
+	}
+	s.exts = nil
+	for _, ext := range splitList(s.Ext) {
+		s.exts = append(s.exts, "."+strings.TrimPrefix(strings.ToLower(ext), "."))
+	}
+	s.minSize = common.HumanSize2Bytes(s.MinSize)
+	s.maxSize = common.HumanSize2Bytes(s.MaxSize)
+	return nil
+}
+
+// Route is a routing rule, the first route whose selector matches wins
+type Route struct {
+	Selector `yaml:",inline"`
+
+	// destination names, and fan-out mode all or any
+	To     []string `yaml:"to"`
+	Fanout string   `yaml:"fanout"`
+}
+
+// init check the rule and parse its conditions
+func (r *Route) init() error {
+	if err := r.Selector.init(); err != nil {
+		return err
+	}
+	if len(r.To) == 0 {
+		return fmt.Errorf("route without destination")
+	}
+	switch r.Fanout {
+	case "":
+		r.Fanout = FanoutAll
+	case FanoutAll, FanoutAny:
+	default:
+		return fmt.Errorf("unknown route fanout: %s", r.Fanout)
+	}
+	return nil
+}
+
+// Router pick destinations of file by routes, and its priority class
+type Router struct {
+	Routes       []Route
+	Destinations map[string]*Destination
+
+	// priority classes, files matching none are in the default class
+	// after them. classes with own queue send through lane destinations
+	Classes []Priority
+	lanes   map[string]*Destination
+
+	fallback Route
+}
+
+// NewRouter build destinations and routes of config, files matching no
+// route go to the default destination
+func NewRouter(opts *AppConfigOption, retry RetryPolicy) (*Router, error) {
+	names := []string{DefaultDestination}
+	for name := range opts.Destinations {
+		if name != DefaultDestination {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	router := &Router{
+		Routes:       make([]Route, len(opts.Routes)),
+		Destinations: make(map[string]*Destination),
+		fallback:     Route{To: []string{DefaultDestination}, Fanout: FanoutAll},
+	}
+	for _, name := range names {
+		destOpts, err := opts.DestinationOptions(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := CheckBackend(destOpts.Backend); err != nil {
+			return nil, fmt.Errorf("destination %s: %s", name, err)
+		}
+		router.Destinations[name] = NewDestination(name, destOpts, retry)
+	}
+
+	if err := router.initClasses(opts, names); err != nil {
+		return nil, err
+	}
+
+	copy(router.Routes, opts.Routes)
+	for i := range router.Routes {
+		route := &router.Routes[i]
+		if err := route.init(); err != nil {
+			return nil, fmt.Errorf("route %d: %s", i, err)
+		}
+		for _, name := range route.To {
+			if _, ok := router.Destinations[name]; !ok {
+				return nil, fmt.Errorf("route %d: unknown destination: %s", i, name)
+			}
+		}
+	}
+	return router, nil
+}
+
+// initClasses check priority classes and create lane destinations of
+// classes overriding queue, lanes share connection of their destination
+func (r *Router) initClasses(opts *AppConfigOption, names []string) error {
+	r.Classes = make([]Priority, len(opts.Priorities))
+	r.lanes = make(map[string]*Destination)
+	copy(r.Classes, opts.Priorities)
+
+	seen := make(map[string]bool)
+	for i := range r.Classes {
+		class := &r.Classes[i]
+		if err := class.init(); err != nil {
+			return fmt.Errorf("priority %d: %s", i, err)
+		}
+		if seen[class.Name] {
+			return fmt.Errorf("duplicate priority: %s", class.Name)
+		}
+		seen[class.Name] = true
+		if class.Queue == "" && class.QueueLimit == 0 {
+			continue
+		}
+
+		for _, name := range names {
+			destOpts, err := opts.DestinationOptions(name)
+			if err != nil {
+				return err
+			}
+			if class.Queue != "" && destOpts.Backend == BackendSpool {
+				return fmt.Errorf("priority %s: spool destination %s has no queue", class.Name, name)
+			}
+			queue, limit := destOpts.DestinationRedisQueueName, destOpts.DestinationRedisQueueLimit
+			if class.Queue != "" {
+				queue = class.Queue
+			}
+			if class.QueueLimit > 0 {
+				limit = class.QueueLimit
+			}
+			lane := laneName(name, class.Name)
+			r.lanes[lane] = r.Destinations[name].Lane(lane, queue, limit)
+		}
+	}
+	return nil
+}
+
+// Class return index of the first priority class matching file item, or
+// len(Classes) for default class
+func (r *Router) Class(item FileItem) int {
+	for i := range r.Classes {
+		if r.Classes[i].Match(item) {
+			return i
+		}
+	}
+	return len(r.Classes)
+}
+
+// Weights return weights of classes and default class
+func (r *Router) Weights() []int {
+	weights := make([]int, 0, len(r.Classes)+1)
+	for _, class := range r.Classes {
+		weights = append(weights, class.Weight)
+	}
+	return append(weights, 1)
+}
+
+// Destination return named destination serving class
+func (r *Router) Destination(name string, class int) *Destination {
+	if class < len(r.Classes) {
+		if lane, ok := r.lanes[laneName(name, r.Classes[class].Name)]; ok {
+			return lane
+		}
+	}
+	return r.Destinations[name]
+}
+
+// Route return the first route matching file item
+func (r *Router) Route(item FileItem) *Route {
+	for i := range r.Routes {
+		if r.Routes[i].Match(item) {
+			return &r.Routes[i]
+		}
+	}
+	return &r.fallback
+}
+
+// Close close all destinations
+func (r *Router) Close() error {
+	var err error
+	for _, lane := range r.lanes {
+		if cerr := lane.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, dest := range r.Destinations {
+		if cerr := dest.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// DestinationOptions return options of named destination, the keys set
+// under the destination override top level options
+func (o *AppConfigOption) DestinationOptions(name string) (*AppConfigOption, error) {
+	destOpts := *o
+	destOpts.Destinations = nil
+	destOpts.Routes = nil
+	destOpts.Priorities = nil
+
+	overlay, ok := o.Destinations[name]
+	if !ok {
+		if name == DefaultDestination {
+			return &destOpts, nil
+		}
+		return nil, fmt.Errorf("unknown destination: %s", name)
+	}
+	data, err := yaml.Marshal(overlay)
+	if err == nil {
+		err = yaml.UnmarshalStrict(data, &destOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("destination %s: %s", name, err)
+	}
+	return &destOpts, nil
+}
@@ -0,0 +1,183 @@
+// Test Suit for routing
+package colly
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestRoute_Match(t *testing.T) {
+	routes := []Route{
+		{Selector: Selector{Glob: "*.log"}, To: []string{"a"}},
+		{Selector: Selector{Glob: "app/*/err.txt"}, To: []string{"a"}},
+		{Selector: Selector{Ext: "JPG, png"}, To: []string{"a"}},
+		{Selector: Selector{Dir: "/images/"}, To: []string{"a"}},
+		{Selector: Selector{MinSize: "1K", MaxSize: "2K"}, To: []string{"a"}},
+	}
+	cases := []struct {
+		route int
+		item  FileItem
+		match bool
+	}{
+		{0, FileItem{FileIndex: "/x/y/app.log"}, true},
+		{0, FileItem{FileIndex: "/app.log.1"}, false},
+		{1, FileItem{FileIndex: "/app/web/err.txt"}, true},
+		{1, FileItem{FileIndex: "/err.txt"}, false},
+		{2, FileItem{FileIndex: "/a.jpg"}, true},
+		{2, FileItem{FileIndex: "/a.PNG"}, true},
+		{2, FileItem{FileIndex: "/a.gif"}, false},
+		{3, FileItem{FileIndex: "/images/a/b.gif"}, true},
+		{3, FileItem{FileIndex: "/images2/b.gif"}, false},
+		{4, FileItem{FileIndex: "/a", FileSize: 1500}, true},
+		{4, FileItem{FileIndex: "/a", FileSize: 500}, false},
+		{4, FileItem{FileIndex: "/a", FileSize: 4096}, false},
+	}
+
+	for i := range routes {
+		if err := routes[i].init(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range cases {
+		if routes[c.route].Match(c.item) != c.match {
+			t.Errorf("route %d match %s should be %v", c.route, c.item.FileIndex, c.match)
+		}
+	}
+}
+
+func TestNewRouter(t *testing.T) {
+	var opts AppConfigOption
+	err := yaml.Unmarshal([]byte(`
+redis_host: 10.0.0.1
+dest_queue: files
+destinations:
+  archive:
+    dest_queue: archive
+    backend: stream
+routes:
+  - ext: log
+    to: [default, archive]
+    fanout: any
+`), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router, err := NewRouter(&opts, RetryPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(router.Destinations) != 2 {
+		t.Errorf("unexpected destinations: %v", router.Destinations)
+	}
+	if route := router.Route(FileItem{FileIndex: "/a.log"}); route.Fanout != FanoutAny || len(route.To) != 2 {
+		t.Errorf("log file should be routed to both: %+v", route)
+	}
+	if route := router.Route(FileItem{FileIndex: "/a.txt"}); route.Fanout != FanoutAll || route.To[0] != DefaultDestination {
+		t.Errorf("unmatched file should go to default: %+v", route)
+	}
+
+	archive, err := opts.DestinationOptions("archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if archive.RedisHost != "10.0.0.1" || archive.DestinationRedisQueueName != "archive" || archive.Backend != BackendStream {
+		t.Errorf("destination should override and inherit options: %+v", archive)
+	}
+
+	invalid := []string{
+		"routes: [{to: [missing]}]",
+		"routes: [{ext: log}]",
+		"routes: [{to: [default], fanout: some}]",
+		"routes: [{glob: '[', to: [default]}]",
+		"destinations: {a: {dest_queu: x}}",
+		"destinations: {a: {backend: kafka}}",
+	}
+	for _, conf := range invalid {
+		var opts AppConfigOption
+		if err := yaml.Unmarshal([]byte(conf), &opts); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewRouter(&opts, RetryPolicy{}); err == nil {
+			t.Errorf("config should be rejected: %s", conf)
+		}
+	}
+}
+
+func TestCollector_Fanout(t *testing.T) {
+	c := newTestCollector(0)
+	retry := RetryPolicy{Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	up, other, failing := &flakyWriter{}, &flakyWriter{}, &flakyWriter{failures: 100}
+	c.Router = &Router{Destinations: map[string]*Destination{
+		"up":      {Name: "up", Retry: retry, Connect: func() (DestWriter, error) { return up, nil }},
+		"other":   {Name: "other", Retry: retry, Connect: func() (DestWriter, error) { return other, nil }},
+		"failing": {Name: "failing", Retry: retry, Connect: func() (DestWriter, error) { return failing, nil }},
+		"down": {Name: "down", Retry: retry, Connect: func() (DestWriter, error) {
+			return nil, errors.New("connection refused")
+		}},
+	}}
+
+	r := EncodeResult{Path: "a", EncodeContent: "x"}
+	cases := []struct {
+		route Route
+		ok    bool
+		sent  int
+		other int
+	}{
+		{Route{To: []string{"up", "failing"}, Fanout: FanoutAll}, false, 1, 0},
+		// a failure does not stop sending to the others
+		{Route{To: []string{"failing", "up", "other"}, Fanout: FanoutAll}, false, 1, 1},
+		{Route{To: []string{"down", "failing", "up"}, Fanout: FanoutAny}, true, 1, 0},
+		{Route{To: []string{"down", "failing"}, Fanout: FanoutAny}, false, 0, 0},
+		{Route{To: []string{"up", "up"}, Fanout: FanoutAll}, true, 2, 0},
+		// any mode is fan-out too, every healthy destination gets the file
+		{Route{To: []string{"up", "other"}, Fanout: FanoutAny}, true, 1, 1},
+	}
+	for i, cs := range cases {
+		up.sent, other.sent = nil, nil
+		// each case is a new file version, nothing acknowledged yet
+		r.Item.FilePath = fmt.Sprintf("a%d", i)
+		err := c.fanout(&cs.route, r)
+		if (err == nil) != cs.ok || len(up.sent) != cs.sent || len(other.sent) != cs.other {
+			t.Errorf("case %d: fanout error %v, sent %d and %d", i, err, len(up.sent), len(other.sent))
+		}
+	}
+}
+
+func TestCollector_FanoutPartial(t *testing.T) {
+	c := newTestCollector(0)
+	retry := RetryPolicy{Backoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	up, full := &flakyWriter{}, &flakyWriter{failures: 100}
+	c.Router = &Router{Destinations: map[string]*Destination{
+		"up":   {Name: "up", Retry: retry, Connect: func() (DestWriter, error) { return up, nil }},
+		"full": {Name: "full", Retry: retry, Connect: func() (DestWriter, error) { return full, nil }},
+	}}
+	route := &Route{To: []string{"up", "full"}, Fanout: FanoutAll}
+
+	// destination refusing the file for several passes
+	r := EncodeResult{Path: "a", EncodeContent: "x", Item: FileItem{FilePath: "a", ModTime: 1}}
+	for i := 0; i < 3; i++ {
+		if err := c.fanout(route, r); err == nil {
+			t.Fatal("fanout should fail while a destination refuses")
+		}
+	}
+	full.failures = 0
+	if err := c.fanout(route, r); err != nil {
+		t.Fatal(err)
+	}
+	if len(up.sent) != 1 || len(full.sent) != 1 {
+		t.Errorf("each destination should get the file once, got %d and %d", len(up.sent), len(full.sent))
+	}
+
+	// a new version of the file is sent again
+	r.Item.ModTime = 2
+	if err := c.fanout(route, r); err != nil || len(up.sent) != 2 {
+		t.Errorf("new version should be sent: %v", err)
+	}
+}
@@ -0,0 +1,464 @@
+// S3 compatible object storage destination
+package colly
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/smileboywtu/FileColly/common"
+)
+
+// S3MinPartSize is the smallest part S3 accepts in multipart upload,
+// except the last one
+const S3MinPartSize = 5 << 20
+
+// ReferenceS3 is the store of references to S3 objects
+const ReferenceS3 = "s3"
+
+// S3Client is a minimal S3 compatible client, requests are signed with
+// signature version 4 and objects are addressed in path style, which
+// works with AWS, minio, ceph and the like
+type S3Client struct {
+	// scheme and host, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// objects larger than PartSize are uploaded in parts of this size
+	PartSize int64
+
+	Client *http.Client
+}
+
+// s3Error is the error body of S3 response
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// s3StatusError is the error of non 2xx response
+type s3StatusError struct {
+	s3Error
+	Method     string
+	Key        string
+	Status     string
+	StatusCode int
+}
+
+func (e *s3StatusError) Error() string {
+	return fmt.Sprintf("s3 %s %s error: %s %s %s", e.Method, e.Key, e.Status, e.Code, e.Message)
+}
+
+// PutObject upload object, in multipart if it's larger than part size.
+// data is a string so the message is never copied
+func (c *S3Client) PutObject(key string, data string) error {
+	if c.PartSize > 0 && int64(len(data)) > c.PartSize {
+		return c.putMultipart(key, data)
+	}
+	resp, err := c.do(http.MethodPut, key, nil, data)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetObject download object
+func (c *S3Client) GetObject(key string) ([]byte, error) {
+	resp, err := c.do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+// DeleteObject remove object, a missing object is deleted too
+func (c *S3Client) DeleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// HeadBucket check if bucket is reachable with the credentials
+func (c *S3Client) HeadBucket() error {
+	resp, err := c.do(http.MethodHead, "", nil, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Fetch download the object reference points to
+func (c *S3Client) Fetch(ref *Reference) ([]byte, error) {
+	if ref.Store != ReferenceS3 || ref.Bucket != c.Bucket {
+		return nil, fmt.Errorf("reference %s://%s/%s is not in bucket %s", ref.Store, ref.Bucket, ref.Key, c.Bucket)
+	}
+	data, err := c.GetObject(ref.Key)
+	if e, ok := err.(*s3StatusError); ok && e.StatusCode == http.StatusNotFound {
+		return nil, pkgerrors.Wrap(ErrClaimMissing, err.Error())
+	}
+	return data, err
+}
+
+// Remove delete the object reference points to once it's restored
+func (c *S3Client) Remove(ref *Reference) error {
+	return c.DeleteObject(ref.Key)
+}
+
+// putMultipart upload object in parts, the upload is aborted on error so
+// no orphan parts are left
+func (c *S3Client) putMultipart(key string, data string) error {
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, "")
+	if err != nil {
+		return err
+	}
+	var initiate struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&initiate)
+	resp.Body.Close()
+	if err != nil || initiate.UploadID == "" {
+		return fmt.Errorf("initiate multipart upload of %s error: %v", key, err)
+	}
+
+	if err := c.uploadParts(key, initiate.UploadID, data); err != nil {
+		if resp, err := c.do(http.MethodDelete, key, url.Values{"uploadId": {initiate.UploadID}}, ""); err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *S3Client) uploadParts(key string, uploadID string, data string) error {
+	type part struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	complete := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+
+	for offset, n := int64(0), 1; offset < int64(len(data)); offset, n = offset+c.PartSize, n+1 {
+		end := offset + c.PartSize
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}
+		resp, err := c.do(http.MethodPut, key, query, data[offset:end])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, string(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// complete may fail after 200 is sent, the error is in body then
+	var result s3Error
+	if xml.NewDecoder(resp.Body).Decode(&result) == nil && result.Code != "" {
+		return fmt.Errorf("complete multipart upload of %s error: %s: %s", key, result.Code, result.Message)
+	}
+	return nil
+}
+
+// do send signed request on object key, or on bucket if key is empty.
+// non 2xx response is returned as error
+func (c *S3Client) do(method string, key string, query url.Values, body string) (*http.Response, error) {
+	target := strings.TrimSuffix(c.Endpoint, "/") + "/" + s3Escape(c.Bucket, false)
+	if key != "" {
+		target += "/" + s3Escape(key, true)
+	}
+	if len(query) > 0 {
+		target += "?" + s3Query(query)
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	c.sign(req, body, time.Now())
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	result := &s3StatusError{Method: method, Key: key, Status: resp.Status, StatusCode: resp.StatusCode}
+	xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&result.s3Error)
+	return nil, result
+}
+
+// hashString return hex sha256 of string, it's hashed in pieces so the
+// string is not copied as a whole
+func hashString(s string) string {
+	h := sha256.New()
+	for len(s) > 0 {
+		n := len(s)
+		if n > 32<<10 {
+			n = 32 << 10
+		}
+		h.Write([]byte(s[:n]))
+		s = s[n:]
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sign add signature version 4 headers to request
+func (c *S3Client) sign(req *http.Request, body string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + c.Region + "/s3/aws4_request"
+	payloadHash := hashString(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// host and x-amz-* are signed, plus range and content-md5 if set
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-md5" {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonical bytes.Buffer
+	canonical.WriteString(req.Method + "\n")
+	canonical.WriteString(s3Escape(req.URL.Path, true) + "\n")
+	canonical.WriteString(s3Query(req.URL.Query()) + "\n")
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonical.WriteString("\n" + signedHeaders + "\n")
+	canonical.WriteString(payloadHash)
+
+	canonicalHash := sha256.Sum256(canonical.Bytes())
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), now.Format("20060102"))
+	for _, step := range []string{c.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, step)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape uri encode as signature version 4 asks, only unreserved
+// characters are kept
+func s3Escape(s string, keepSlash bool) string {
+	var escaped bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'A' <= ch && ch <= 'Z' || 'a' <= ch && ch <= 'z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' || ch == '/' && keepSlash {
+			escaped.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", ch)
+		}
+	}
+	return escaped.String()
+}
+
+// s3Query encode query sorted by key
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key, false)+"="+s3Escape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// S3Writer upload each message as an object named by its message id. in
+// claim-check mode only a reference envelope is pushed to the redis
+// queue, so large files never go through redis
+type S3Writer struct {
+	S3     *S3Client
+	Prefix string
+
+	// reference queue of claim-check mode, nil if disabled
+	Queue *RedisWriter
+}
+
+// NewS3Client init s3 client of config
+func NewS3Client(opts *AppConfigOption) (*S3Client, error) {
+	endpoint, err := url.Parse(opts.S3Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint: %q", opts.S3Endpoint)
+	}
+	if opts.S3Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not set")
+	}
+	partSize := common.HumanSize2Bytes(opts.S3PartSize)
+	if partSize < S3MinPartSize {
+		return nil, fmt.Errorf("s3 part size should be at least 5M: %s", opts.S3PartSize)
+	}
+
+	return &S3Client{
+		Endpoint:  opts.S3Endpoint,
+		Region:    opts.S3Region,
+		Bucket:    opts.S3Bucket,
+		AccessKey: opts.S3AccessKey,
+		SecretKey: opts.S3SecretKey,
+		PartSize:  partSize,
+		Client:    &http.Client{Timeout: time.Duration(opts.S3Timeout) * time.Second},
+	}, nil
+}
+
+// NewS3Writer init s3 backend of config, the redis queue is connected in
+// claim-check mode
+func NewS3Writer(opts *AppConfigOption) (*S3Writer, error) {
+	client, err := NewS3Client(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &S3Writer{S3: client, Prefix: opts.S3Prefix}
+	if opts.S3ClaimCheck {
+		redisClient, err := opts.RedisClient()
+		if err != nil {
+			return nil, err
+		}
+		w.Queue, err = NewRedisWriterWithClient(redisClient, opts.DestinationRedisQueueName, opts.DestinationRedisQueueLimit)
+		if err != nil {
+			return nil, err
+		}
+		w.Queue.MaxMemory = common.HumanSize2Bytes(opts.RedisMaxMemory)
+	}
+	return w, nil
+}
+
+// GetDestQueueSize get reference queue size in claim-check mode
+func (w *S3Writer) GetDestQueueSize() int64 {
+	if w.Queue == nil {
+		return 0
+	}
+	return w.Queue.GetDestQueueSize()
+}
+
+// IsAllow check reference queue limit in claim-check mode
+func (w *S3Writer) IsAllow() bool {
+	return w.Queue == nil || w.Queue.IsAllow()
+}
+
+// Check checks if bucket and reference queue are reachable
+func (w *S3Writer) Check() bool {
+	if w.Queue != nil && !w.Queue.Check() {
+		return false
+	}
+	return w.S3.HeadBucket() == nil
+}
+
+// SendFileContent upload message, then push its reference in claim-check
+// mode. the object is removed again when the queue refuses the reference,
+// on other push errors it is left in bucket, a retry uploads it again
+// under the same key as message id is stable for the file
+func (w *S3Writer) SendFileContent(buffer string) error {
+	envelope, err := DecodeEnvelope([]byte(buffer))
+	if err != nil {
+		return err
+	}
+	if w.Queue != nil && !w.Queue.IsAllow() {
+		return ErrQueueFull
+	}
+
+	key := path.Join(w.Prefix, envelope.MessageID+SpoolSuffix)
+	if err := w.S3.PutObject(key, buffer); err != nil {
+		return err
+	}
+	if w.Queue == nil {
+		return nil
+	}
+
+	ref, err := envelope.Reference(&Reference{
+		Store:  ReferenceS3,
+		Bucket: w.S3.Bucket,
+		Key:    key,
+		Size:   int64(len(buffer)),
+	})
+	if err != nil {
+		return err
+	}
+	err = w.Queue.SendFileContent(ref)
+	if err == ErrQueueFull || IsRejected(err) {
+		w.S3.DeleteObject(key)
+	}
+	return err
+}
+
+// Lane return writer pushing references to another queue, objects go
+// to the same bucket
+func (w *S3Writer) Lane(queue string, limit int) DestWriter {
+	if w.Queue == nil {
+		return w
+	}
+	return &S3Writer{S3: w.S3, Prefix: w.Prefix, Queue: w.Queue.lane(queue, limit)}
+}
+
+// Close close reference queue
+func (w *S3Writer) Close() error {
+	if w.Queue != nil {
+		return w.Queue.Close()
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+// Local spool directory destination
+package colly
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpoolSuffix is the suffix of complete message files in spool directory,
+// files being written have a temporary name without it
+const SpoolSuffix = ".msg"
+
+const spoolTempPrefix = ".tmp-"
+
+// temporary file older than spoolTempAge is left by a crash, younger
+// ones may be written by other writers of the directory
+const spoolTempAge = time.Minute
+
+// SpoolWriter write each message as a file in spool directory, so files
+// can be collected on hosts without redis and shipped later. files are
+// named by an increasing sequence, reading them in name order keeps the
+// send order
+type SpoolWriter struct {
+	Directory string
+	// max message files in directory, 0 refuses every message, negative
+	// for no limit
+	Limit int
+
+	*spoolSequence
+}
+
+// spoolSequence is the last name taken and the message files counted in
+// spool directory, lanes share the one of their writer
+type spoolSequence struct {
+	sync.Mutex
+
+	seq   uint64
+	count int
+}
+
+// NewSpoolWriter init spool backend in directory, sequence continues from
+// the files left there or current time, whichever is larger, so names
+// keep increasing after the spool is shipped and emptied. temporary files
+// left by a crash are removed
+func NewSpoolWriter(directory string, limit int) (*SpoolWriter, error) {
+	if directory == "" {
+		return nil, fmt.Errorf("spool directory is not set")
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+	temps, _ := filepath.Glob(filepath.Join(directory, spoolTempPrefix+"*"))
+	for _, temp := range temps {
+		if info, err := os.Stat(temp); err == nil && time.Since(info.ModTime()) > spoolTempAge {
+			os.Remove(temp)
+		}
+	}
+
+	w := &SpoolWriter{
+		Directory:     directory,
+		Limit:         limit,
+		spoolSequence: &spoolSequence{seq: uint64(time.Now().UnixNano())},
+	}
+	names, err := SpoolMessages(w.Directory)
+	if err != nil {
+		return nil, err
+	}
+	w.count = len(names)
+	if len(names) > 0 {
+		last, _ := strconv.ParseUint(strings.TrimSuffix(names[len(names)-1], SpoolSuffix), 10, 64)
+		if last > w.seq {
+			w.seq = last
+		}
+	}
+	return w, nil
+}
+
+// GetDestQueueSize get message files in spool directory
+func (w *SpoolWriter) GetDestQueueSize() int64 {
+	names, err := SpoolMessages(w.Directory)
+	if err != nil {
+		return 0
+	}
+	return int64(len(names))
+}
+
+// IsAllow check if spool directory is out of limit
+func (w *SpoolWriter) IsAllow() bool {
+	return w.Limit < 0 || w.GetDestQueueSize() < int64(w.Limit)
+}
+
+// Check checks if spool directory is writable
+func (w *SpoolWriter) Check() bool {
+	info, err := os.Stat(w.Directory)
+	return err == nil && info.IsDir()
+}
+
+// SendFileContent write message into a temporary file and link it to
+// its sequence name once it's synced, the directory is synced too before
+// the message counts as sent. the name is never overwritten, a name taken
+// by another writer of the directory moves on to next sequence
+func (w *SpoolWriter) SendFileContent(buffer string) error {
+	name, err := w.reserve()
+	if err != nil {
+		return err
+	}
+
+	temp, err := writeTemp(w.Directory, []byte(buffer))
+	if err != nil {
+		w.release()
+		return err
+	}
+	defer os.Remove(temp)
+	for {
+		err := os.Link(temp, filepath.Join(w.Directory, name))
+		if err == nil {
+			if err = syncDir(w.Directory); err != nil {
+				os.Remove(filepath.Join(w.Directory, name))
+				w.release()
+			}
+			return err
+		}
+		if !os.IsExist(err) {
+			w.release()
+			return err
+		}
+		name = w.next()
+	}
+}
+
+// Lane return writer of the same directory under another limit, spool
+// has no queue name so router refuses classes with their own queue on
+// it. names keep taken from one sequence
+func (w *SpoolWriter) Lane(queue string, limit int) DestWriter {
+	return &SpoolWriter{Directory: w.Directory, Limit: limit, spoolSequence: w.spoolSequence}
+}
+
+// Close nothing to release
+func (w *SpoolWriter) Close() error {
+	return nil
+}
+
+// reserve take next sequence name and a slot under the limit, the
+// directory is counted again when the limit is reached since files may
+// have been shipped
+func (w *SpoolWriter) reserve() (string, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	if w.Limit >= 0 && w.count >= w.Limit {
+		names, err := SpoolMessages(w.Directory)
+		if err != nil {
+			return "", err
+		}
+		if w.count = len(names); w.count >= w.Limit {
+			return "", ErrQueueFull
+		}
+	}
+	w.count++
+	w.seq++
+	return fmt.Sprintf("%020d%s", w.seq, SpoolSuffix), nil
+}
+
+// next take next sequence name for a slot already reserved
+func (w *SpoolWriter) next() string {
+	w.Lock()
+	defer w.Unlock()
+	w.seq++
+	return fmt.Sprintf("%020d%s", w.seq, SpoolSuffix)
+}
+
+func (w *SpoolWriter) release() {
+	w.Lock()
+	w.count--
+	w.Unlock()
+}
+
+// SpoolMessages list complete message files of spool directory in send
+// order
+func SpoolMessages(directory string) ([]string, error) {
+	infos, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() && strings.HasSuffix(info.Name(), SpoolSuffix) && !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// writeTemp write data into a synced temporary file of directory, the
+// name is unique among all writers of the directory
+func writeTemp(directory string, data []byte) (string, error) {
+	fd, err := ioutil.TempFile(directory, spoolTempPrefix)
+	if err != nil {
+		return "", err
+	}
+	temp := fd.Name()
+	err = fd.Chmod(0644)
+	if err == nil {
+		_, err = fd.Write(data)
+	}
+	if err == nil {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp)
+		return "", err
+	}
+	return temp, nil
+}
+
+// syncDir sync directory entries, so a file linked into it survives a
+// crash
+func syncDir(directory string) error {
+	fd, err := os.Open(directory)
+	if err != nil {
+		return err
+	}
+	err = fd.Sync()
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+// Test Suit for spool backend
+package colly
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/smileboywtu/FileColly/common"
+)
+
+func TestSpoolWriter_SendFileContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// temporary file of a crashed write, and one being written by another
+	// writer
+	crashed := filepath.Join(dir, spoolTempPrefix+"1.msg")
+	ioutil.WriteFile(crashed, []byte("x"), 0644)
+	old := time.Now().Add(-2 * spoolTempAge)
+	os.Chtimes(crashed, old, old)
+	writing := filepath.Join(dir, spoolTempPrefix+"2.msg")
+	ioutil.WriteFile(writing, []byte("x"), 0644)
+
+	w, err := NewSpoolWriter(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []string{"a", "b", "c"} {
+		if err := w.SendFileContent(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.SendFileContent("d"); err != ErrQueueFull {
+		t.Errorf("spool should be full: %v", err)
+	}
+
+	names, err := SpoolMessages(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(writing); err != nil {
+		t.Error("temporary file being written should be kept")
+	}
+	infos, _ := ioutil.ReadDir(dir)
+	if len(names) != 3 || len(infos) != 3 || w.IsAllow() {
+		t.Fatalf("unexpected spool files: %v", infos)
+	}
+	for i, m := range []string{"a", "b", "c"} {
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, names[i])); string(data) != m {
+			t.Errorf("spool file %s should be %s in order, got %s", names[i], m, data)
+		}
+	}
+
+	// shipped files free the limit, names keep increasing after reopen
+	os.Remove(filepath.Join(dir, names[0]))
+	if err := w.SendFileContent("d"); err != nil {
+		t.Fatal(err)
+	}
+	w, err = NewSpoolWriter(dir, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SendFileContent("e"); err != nil {
+		t.Fatal(err)
+	}
+	names, _ = SpoolMessages(dir)
+	if len(names) != 4 {
+		t.Fatalf("unexpected spool files: %v", names)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, names[3])); string(data) != "e" {
+		t.Errorf("last spool file should be e, got %s", data)
+	}
+
+	// another writer of the directory never overwrites a message
+	other, err := NewSpoolWriter(dir, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.seq = w.seq - 1
+	if err := other.SendFileContent("f"); err != nil {
+		t.Fatal(err)
+	}
+	names, _ = SpoolMessages(dir)
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, names[3])); len(names) != 5 || string(data) != "e" {
+		t.Errorf("taken spool name should be skipped: %v", names)
+	}
+}
+
+func TestSpoolWriter_Lane(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewSpoolWriter(dir, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lane := w.Lane("bulk", 2)
+
+	// lane takes names from the sequence of its writer
+	w.SendFileContent("a")
+	lane.SendFileContent("b")
+	w.SendFileContent("c")
+	if err := lane.SendFileContent("d"); err != ErrQueueFull {
+		t.Errorf("lane should stop at its own limit: %v", err)
+	}
+
+	names, _ := SpoolMessages(dir)
+	var got string
+	for _, name := range names {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		got += string(data)
+	}
+	if got != "abc" {
+		t.Errorf("spool files should keep send order, got %q", got)
+	}
+}
+
+func TestCollector_StartSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	collect := filepath.Join(dir, "files")
+	os.MkdirAll(filepath.Join(collect, "sub"), 0755)
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		ioutil.WriteFile(filepath.Join(collect, name), []byte(name), 0644)
+	}
+
+	opts := &AppConfigOption{}
+	if err := common.ApplyDefaultValues(opts); err != nil {
+		t.Fatal(err)
+	}
+	opts.Backend = BackendSpool
+	opts.SpoolDirectory = filepath.Join(collect, "spool")
+	opts.CollectDirectory = collect
+	opts.JournalFile = ""
+	opts.LogFileName = filepath.Join(os.TempDir(), "colly_test.log")
+	opts.ReaderMaxWorkers, opts.SenderMaxWorkers = 2, 2
+
+	c, err := NewCollector(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.Start()
+
+	names, _ := SpoolMessages(opts.SpoolDirectory)
+	if len(names) != 2 || c.GetFileCount() != 2 {
+		t.Fatalf("files should be spooled: %v", names)
+	}
+	for _, name := range []string{"a.txt", "sub/b.txt"} {
+		if _, err := os.Stat(filepath.Join(collect, name)); !os.IsNotExist(err) {
+			t.Errorf("spooled file %s should be removed", name)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Check files are completely written before collecting them
+package colly
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// min interval between two scans of open files
+	openCheckInterval = time.Second
+	// observations of files not seen for this long are forgotten
+	observeTimeout = 10 * time.Minute
+)
+
+// observation is the state of a file when it was last seen
+type observation struct {
+	size  int64
+	mtime int64
+	inode uint64
+	seen  time.Time
+}
+
+// Stability skip files a writer may not be done with, beyond the read
+// wait time rule. every strategy set must pass
+type Stability struct {
+	sync.Mutex
+
+	// globs on file name of temp files never collected, e.g. *.tmp
+	TempPatterns []string
+	// suffixes of marker files, e.g. .done: foo is only collected once
+	// foo.done exists, marker files are never collected
+	Markers []string
+	// collect a file only if its size and mtime are unchanged since it
+	// was last observed
+	Observe bool
+	// skip files opened for writing by any process visible in /proc
+	OpenCheck bool
+
+	observed  map[string]observation
+	lastPrune time.Time
+
+	writers   map[string]struct{}
+	writersAt time.Time
+}
+
+// NewStability create stability checks of options, nil if none is set
+func NewStability(opts *AppConfigOption) (*Stability, error) {
+	s := &Stability{
+		TempPatterns: splitList(opts.TempPatterns),
+		Observe:      opts.StableObserve,
+		OpenCheck:    opts.StableOpenCheck,
+		observed:     make(map[string]observation),
+	}
+	for _, pattern := range s.TempPatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid temp pattern: %s", pattern)
+		}
+	}
+	for _, marker := range splitList(opts.StableMarkers) {
+		s.Markers = append(s.Markers, "."+strings.TrimPrefix(marker, "."))
+	}
+	if s.OpenCheck {
+		if _, err := openWriters(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(s.TempPatterns) == 0 && len(s.Markers) == 0 && !s.Observe && !s.OpenCheck {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// Accept is the walker filter of stability checks
+func (s *Stability) Accept(path string, rule Rule) bool {
+	if s == nil {
+		return true
+	}
+
+	name := filepath.Base(path)
+	for _, pattern := range s.TempPatterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return false
+		}
+	}
+
+	if len(s.Markers) > 0 {
+		if data := s.markerOf(path); data != "" {
+			s.removeOrphan(path, data, rule)
+			return false
+		}
+		if !s.hasMarker(path) {
+			return false
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if s.Observe && !s.observe(path, info) {
+		return false
+	}
+	if s.OpenCheck && s.openForWrite(path) {
+		return false
+	}
+	return true
+}
+
+// Target return the file a watch event should check, the data file for
+// a marker file. the file is observed once here so it can be accepted
+// after the watch settle time
+func (s *Stability) Target(path string) string {
+	if s == nil {
+		return path
+	}
+	if data := s.markerOf(path); data != "" {
+		path = data
+	}
+	if s.Observe {
+		if info, err := os.Stat(path); err == nil {
+			s.observe(path, info)
+		}
+	}
+	return path
+}
+
+// markerOf return data file of marker file, empty if path is not a marker
+func (s *Stability) markerOf(path string) string {
+	for _, marker := range s.Markers {
+		if strings.HasSuffix(path, marker) && len(filepath.Base(path)) > len(marker) {
+			return strings.TrimSuffix(path, marker)
+		}
+	}
+	return ""
+}
+
+// hasMarker check if any marker of file exists
+func (s *Stability) hasMarker(path string) bool {
+	for _, marker := range s.Markers {
+		if _, err := os.Stat(path + marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// removeOrphan remove marker whose data file is gone, e.g. released,
+// the marker is kept for read wait time in case data file is moved in
+func (s *Stability) removeOrphan(marker string, data string, rule Rule) {
+	if _, err := os.Lstat(data); !os.IsNotExist(err) {
+		return
+	}
+	info, err := os.Stat(marker)
+	if err != nil || info.ModTime().Unix()+int64(rule.CollectWaitTime) > time.Now().Unix() {
+		return
+	}
+	if err := os.Remove(marker); err != nil && !os.IsNotExist(err) {
+		logger.Printf("remove orphan marker: %s error: %s", marker, err)
+	}
+}
+
+// observe record file state and check if it is unchanged since last seen
+func (s *Stability) observe(path string, info os.FileInfo) bool {
+	now := time.Now()
+	current := observation{
+		size:  info.Size(),
+		mtime: info.ModTime().UnixNano(),
+		inode: fileInode(info),
+		seen:  now,
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if now.Sub(s.lastPrune) > time.Minute {
+		for p, o := range s.observed {
+			if now.Sub(o.seen) > observeTimeout {
+				delete(s.observed, p)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	last, ok := s.observed[path]
+	s.observed[path] = current
+	return ok && last.size == current.size && last.mtime == current.mtime && last.inode == current.inode
+}
+
+// openForWrite check if file is opened for writing, open files are
+// scanned at most once per check interval
+func (s *Stability) openForWrite(path string) bool {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if time.Since(s.writersAt) > openCheckInterval {
+		writers, err := openWriters()
+		if err != nil {
+			logger.Printf("scan open files error: %s", err)
+			// keep the file until writers can be checked
+			return true
+		}
+		s.writers, s.writersAt = writers, time.Now()
+	}
+	_, ok := s.writers[path]
+	return ok
+}
@@ -0,0 +1,69 @@
+// Find files opened for writing in /proc
+package colly
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// openWriters return paths of files opened for writing by processes the
+// collector is allowed to inspect
+func openWriters() (map[string]struct{}, error) {
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+
+	writers := make(map[string]struct{})
+	for _, proc := range procs {
+		if _, err := strconv.Atoi(proc.Name()); err != nil || !proc.IsDir() {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// process exited or belongs to other user
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			// sockets, pipes and anonymous inodes are not paths
+			if err != nil || !strings.HasPrefix(target, "/") {
+				continue
+			}
+			if fdWritable(filepath.Join("/proc", proc.Name(), "fdinfo", fd.Name())) {
+				writers[target] = struct{}{}
+			}
+		}
+	}
+	return writers, nil
+}
+
+// fdWritable check access mode in flags of fdinfo
+func fdWritable(fdinfo string) bool {
+	f, err := os.Open(fdinfo)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "flags:") {
+			continue
+		}
+		flags, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "flags:")), 8, 64)
+		if err != nil {
+			return false
+		}
+		mode := flags & syscall.O_ACCMODE
+		return mode == syscall.O_WRONLY || mode == syscall.O_RDWR
+	}
+	return false
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package colly
+
+import "errors"
+
+// openWriters is only supported on linux
+func openWriters() (map[string]struct{}, error) {
+	return nil, errors.New("open check is only supported on linux")
+}
@@ -0,0 +1,115 @@
+// Test Suit for file stability checks
+package colly
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestStability_Accept(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"a.log", "b.log", "b.log.done", "c.tmp", "d.part", "gone.log.ok"} {
+		ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644)
+	}
+
+	s, err := NewStability(&AppConfigOption{TempPatterns: "*.tmp,*.part", StableMarkers: "done,.ok"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]bool{
+		"a.log":      false,
+		"b.log":      true,
+		"b.log.done": false,
+		"c.tmp":      false,
+		"d.part":     false,
+	}
+	for name, accept := range expect {
+		if s.Accept(filepath.Join(dir, name), Rule{}) != accept {
+			t.Errorf("accept %s should be %v", name, accept)
+		}
+	}
+
+	// orphan marker is removed once older than read wait time
+	orphan := filepath.Join(dir, "gone.log.ok")
+	s.Accept(orphan, Rule{CollectWaitTime: 60})
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatal("new orphan marker should be kept")
+	}
+	s.Accept(orphan, Rule{})
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphan marker should be removed")
+	}
+
+	if data := s.Target(filepath.Join(dir, "b.log.done")); data != filepath.Join(dir, "b.log") {
+		t.Errorf("marker event should check data file: %s", data)
+	}
+	if s, err := NewStability(&AppConfigOption{}); s != nil || err != nil {
+		t.Error("no stability check should be set by default")
+	}
+	if _, err := NewStability(&AppConfigOption{TempPatterns: "["}); err == nil {
+		t.Error("invalid temp pattern should be rejected")
+	}
+}
+
+func TestStability_Observe(t *testing.T) {
+	f, err := ioutil.TempFile("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("part")
+	f.Close()
+
+	s := &Stability{Observe: true, observed: make(map[string]observation)}
+	if s.Accept(f.Name(), Rule{}) {
+		t.Fatal("file seen once should not be accepted")
+	}
+	if !s.Accept(f.Name(), Rule{}) {
+		t.Fatal("file unchanged since last seen should be accepted")
+	}
+
+	ioutil.WriteFile(f.Name(), []byte("part more"), 0644)
+	if s.Accept(f.Name(), Rule{}) {
+		t.Error("file growing should not be accepted")
+	}
+	s.Target(f.Name())
+	if !s.Accept(f.Name(), Rule{}) {
+		t.Error("watch event should count as an observation")
+	}
+}
+
+func TestStability_OpenCheck(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open check is only supported on linux")
+	}
+	f, err := ioutil.TempFile("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	s, err := NewStability(&AppConfigOption{StableOpenCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Accept(f.Name(), Rule{}) {
+		t.Fatal("file opened for writing should not be accepted")
+	}
+
+	f.Close()
+	// read only opens do not hold the file
+	r, _ := os.Open(f.Name())
+	defer r.Close()
+	s.writersAt = time.Time{}
+	if !s.Accept(f.Name(), Rule{}) {
+		t.Error("closed file should be accepted")
+	}
+}
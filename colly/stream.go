@@ -0,0 +1,181 @@
+// Redis stream destination
+package colly
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-redis/redis"
+)
+
+// StreamWriter XADD each file to a redis stream for consumers in a group
+// to read, ack and recover pending entries
+type StreamWriter struct {
+	Client     redis.UniversalClient
+	StreamName string
+	// max entries in stream, pushes are refused once reached. the stream
+	// is also trimmed to about MaxLen entries, which only drops entries
+	// added beside the collector. 0 refuses every push, negative for no
+	// limit
+	MaxLen int
+	// pushes pause while redis used_memory is over it, 0 for no limit
+	MaxMemory int64
+
+	memory memoryGuard
+}
+
+// xaddScript check stream length and add entry in one step, so concurrent
+// senders never overshoot the limit. false means stream full
+var xaddScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+if limit >= 0 and redis.call("XLEN", KEYS[1]) >= limit then
+	return false
+end
+return redis.call("XADD", KEYS[1], unpack(ARGV, 2))
+`)
+
+// NewStreamWriter init a new stream backend
+func NewStreamWriter(opts *redis.Options, stream string, maxLen int) (*StreamWriter, error) {
+	w, err := NewStreamWriterWithClient(redis.NewClient(opts), stream, maxLen)
+	if err != nil {
+		return nil, fmt.Errorf("%s, connect params: %s", err, opts.Addr)
+	}
+	return w, nil
+}
+
+// NewStreamWriterWithClient init stream backend on an existing client
+func NewStreamWriterWithClient(client redis.UniversalClient, stream string, maxLen int) (*StreamWriter, error) {
+	pong, err := client.Ping().Result()
+	if err != nil || pong != "PONG" {
+		client.Close()
+		return nil, errors.New(fmt.Sprintf("redis connect error: %s", err))
+	}
+
+	return &StreamWriter{
+		Client:     client,
+		StreamName: stream,
+		MaxLen:     maxLen,
+	}, nil
+}
+
+// GetDestQueueSize get current stream length
+func (w *StreamWriter) GetDestQueueSize() int64 {
+	cmd := redis.NewIntCmd("XLEN", w.StreamName)
+	w.Client.Process(cmd)
+	clen, err := cmd.Result()
+	if err != nil {
+		return 0
+	}
+	return clen
+}
+
+// Check checks if redis client connection is ok
+func (w *StreamWriter) Check() bool {
+	return w.Client.Ping().Err() == nil
+}
+
+// IsAllow check redis memory and stream length
+func (w *StreamWriter) IsAllow() bool {
+	if w.MaxLen >= 0 && w.GetDestQueueSize() >= int64(w.MaxLen) {
+		return false
+	}
+	return !w.memory.Full(w.Client, w.MaxMemory)
+}
+
+// SendFileContent add one file to stream, metadata fields are decoded
+// from the message
+func (w *StreamWriter) SendFileContent(buffer string) error {
+	return w.SendFields(buffer, nil)
+}
+
+// SendFields add one file to stream with its metadata fields, nil
+// fields are decoded from the message
+func (w *StreamWriter) SendFields(buffer string, fields []string) error {
+	if w.memory.Full(w.Client, w.MaxMemory) {
+		return ErrQueueFull
+	}
+	args, err := w.xaddArgs(buffer, fields)
+	if err != nil {
+		return err
+	}
+	return xaddResult(xaddScript.Run(w.Client, []string{w.StreamName}, args...))
+}
+
+// SendBatch add files to stream in one pipeline
+func (w *StreamWriter) SendBatch(buffers []string) []error {
+	return w.SendFieldsBatch(buffers, make([][]string, len(buffers)))
+}
+
+// SendFieldsBatch add files with their metadata fields in one pipeline,
+// each entry still checks the stream limit on its own
+func (w *StreamWriter) SendFieldsBatch(buffers []string, fields [][]string) []error {
+	errs := make([]error, len(buffers))
+	if w.memory.Full(w.Client, w.MaxMemory) {
+		for i := range errs {
+			errs[i] = ErrQueueFull
+		}
+		return errs
+	}
+	cmds := make([]*redis.Cmd, len(buffers))
+
+	pipe := w.Client.Pipeline()
+	defer pipe.Close()
+	for i, buffer := range buffers {
+		args, err := w.xaddArgs(buffer, fields[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		cmds[i] = xaddScript.Eval(pipe, []string{w.StreamName}, args...)
+	}
+	pipe.Exec()
+
+	for i, cmd := range cmds {
+		if cmd != nil {
+			errs[i] = xaddResult(cmd)
+		}
+	}
+	return errs
+}
+
+// Lane return writer adding to another stream on the same client
+func (w *StreamWriter) Lane(queue string, limit int) DestWriter {
+	return &StreamWriter{Client: w.Client, StreamName: queue, MaxLen: limit, MaxMemory: w.MaxMemory}
+}
+
+// Close close redis client
+func (w *StreamWriter) Close() error {
+	return w.Client.Close()
+}
+
+// xaddArgs build script args of stream entry, metadata fields go beside
+// payload so consumers can route without decoding it
+func (w *StreamWriter) xaddArgs(buffer string, fields []string) ([]interface{}, error) {
+	if fields == nil {
+		envelope, err := DecodeEnvelope([]byte(buffer))
+		if err != nil {
+			return nil, err
+		}
+		if fields, err = envelope.Fields(); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []interface{}{w.MaxLen}
+	if w.MaxLen > 0 {
+		args = append(args, "MAXLEN", "~", w.MaxLen)
+	}
+	args = append(args, "*")
+	for _, field := range fields {
+		args = append(args, field)
+	}
+	return append(args, "payload", buffer), nil
+}
+
+func xaddResult(cmd *redis.Cmd) error {
+	err := cmd.Err()
+	if err == redis.Nil {
+		return ErrQueueFull
+	}
+	return err
+}
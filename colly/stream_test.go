@@ -0,0 +1,77 @@
+// Test Suit for redis stream backend
+package colly
+
+import (
+	"testing"
+
+	"github.com/go-redis/redis"
+)
+
+func TestStreamWriter_SendFileContent(t *testing.T) {
+	stream := DestQueueName + ":stream"
+	inst, errs := NewStreamWriter(opts, stream, 100)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	inst.Client.Del(stream)
+	defer inst.Client.Del(stream)
+
+	encoder := FileContentEncoder{FilePath: "/a.txt", FileContent: []byte("hello")}
+	packed, err := encoder.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inst.SendFileContent(packed); err != nil {
+		t.Fatal(err)
+	}
+	results := inst.SendBatch([]string{packed, "not a message"})
+	if results[0] != nil || results[1] == nil {
+		t.Errorf("unexpected batch results: %v", results)
+	}
+	if size := inst.GetDestQueueSize(); size != 2 {
+		t.Errorf("expect 2 entries, got %d", size)
+	}
+
+	cmd := redis.NewSliceCmd("XRANGE", stream, "-", "+", "COUNT", 1)
+	inst.Client.Process(cmd)
+	entries, err := cmd.Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatal(entries, err)
+	}
+	fields := entries[0].([]interface{})[1].([]interface{})
+	values := map[string]string{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		values[fields[i].(string)] = fields[i+1].(string)
+	}
+	if values["path"] != "/a.txt" || values["size"] != "5" || values["codec"] != DefaultCodec ||
+		values["hash"] == "" || values["payload"] != packed {
+		t.Errorf("unexpected stream fields: %v", values)
+	}
+}
+
+func TestStreamWriter_Limit(t *testing.T) {
+	stream := DestQueueName + ":stream:limit"
+	inst, errs := NewStreamWriter(opts, stream, 2)
+	if errs != nil {
+		t.Skip(errs.Error())
+	}
+	inst.Client.Del(stream)
+	defer inst.Client.Del(stream)
+
+	encoder := FileContentEncoder{FilePath: "/a.txt", FileContent: []byte("hello")}
+	packed, fields, err := encoder.EncodeFields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fields passed are used as they are
+	if err := inst.SendFields("not a message", fields); err != nil {
+		t.Fatal(err)
+	}
+	results := inst.SendBatch([]string{packed, packed})
+	if results[0] != nil || results[1] != ErrQueueFull {
+		t.Errorf("push over limit should be refused: %v", results)
+	}
+	if err := inst.SendFileContent(packed); err != ErrQueueFull || inst.GetDestQueueSize() != 2 {
+		t.Errorf("unread entries should never be trimmed: %v", err)
+	}
+}
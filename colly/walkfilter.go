@@ -0,0 +1,143 @@
+// Select files and prune directories while walking
+package colly
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/smileboywtu/FileColly/common"
+)
+
+// WalkFilter select files by their path relative to collect directory,
+// globs match the file name, or the relative path if they have a slash
+type WalkFilter struct {
+	// a file must match one include glob or the include regex if any is set
+	Include      []string
+	IncludeRegex *regexp.Regexp
+	// files matching any exclude glob or the exclude regex are skipped
+	Exclude      []string
+	ExcludeRegex *regexp.Regexp
+	// directories matching any glob are pruned, not walked
+	ExcludeDirs []string
+	// max levels of directories below collect directory, 1 for files in
+	// collect directory only, 0 for no limit
+	MaxDepth int
+	// files smaller or modified longer ago are skipped, 0 for no limit
+	MinSize int64
+	MaxAge  time.Duration
+}
+
+// NewWalkFilter create walk filter of options, nil if none is set
+func NewWalkFilter(opts *AppConfigOption) (*WalkFilter, error) {
+	f := &WalkFilter{
+		Include:     splitList(opts.WalkInclude),
+		Exclude:     splitList(opts.WalkExclude),
+		ExcludeDirs: splitList(opts.WalkExcludeDirs),
+		MaxDepth:    opts.WalkMaxDepth,
+		MinSize:     common.HumanSize2Bytes(opts.WalkMinSize),
+		MaxAge:      time.Duration(opts.WalkMaxAge) * time.Second,
+	}
+	if f.MaxDepth < 0 || f.MaxAge < 0 {
+		return nil, fmt.Errorf("negative walk depth or max age")
+	}
+	for _, globs := range [][]string{f.Include, f.Exclude, f.ExcludeDirs} {
+		for i, glob := range globs {
+			globs[i] = strings.Trim(filepath.ToSlash(glob), "/")
+			if _, err := path.Match(globs[i], ""); err != nil {
+				return nil, fmt.Errorf("invalid walk glob: %s", glob)
+			}
+		}
+	}
+
+	var err error
+	if opts.WalkIncludeRegex != "" {
+		if f.IncludeRegex, err = regexp.Compile(opts.WalkIncludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid include regex: %s", err)
+		}
+	}
+	if opts.WalkExcludeRegex != "" {
+		if f.ExcludeRegex, err = regexp.Compile(opts.WalkExcludeRegex); err != nil {
+			return nil, fmt.Errorf("invalid exclude regex: %s", err)
+		}
+	}
+
+	if len(f.Include) == 0 && len(f.Exclude) == 0 && len(f.ExcludeDirs) == 0 &&
+		f.IncludeRegex == nil && f.ExcludeRegex == nil &&
+		f.MaxDepth == 0 && f.MinSize == 0 && f.MaxAge == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// relPath turn file index into slash separated path without leading slash
+func relPath(index string) string {
+	return strings.Trim(filepath.ToSlash(index), "/")
+}
+
+// depth is the number of path elements of relative path
+func depth(rel string) int {
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// PruneDir check if directory of relative path should not be walked
+func (f *WalkFilter) PruneDir(index string) bool {
+	rel := relPath(index)
+	if f == nil || rel == "" {
+		return false
+	}
+	// files in directory would be deeper than max depth
+	if f.MaxDepth > 0 && depth(rel) >= f.MaxDepth {
+		return true
+	}
+	for _, glob := range f.ExcludeDirs {
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match check if file of relative path and info is selected
+func (f *WalkFilter) Match(index string, info os.FileInfo) bool {
+	if f == nil {
+		return true
+	}
+	rel := relPath(index)
+
+	if f.MaxDepth > 0 && depth(rel) > f.MaxDepth {
+		return false
+	}
+	if f.MinSize > 0 && info.Size() < f.MinSize {
+		return false
+	}
+	if f.MaxAge > 0 && time.Since(info.ModTime()) > f.MaxAge {
+		return false
+	}
+
+	for _, glob := range f.Exclude {
+		if matchGlob(glob, rel) {
+			return false
+		}
+	}
+	if f.ExcludeRegex != nil && f.ExcludeRegex.MatchString(rel) {
+		return false
+	}
+
+	if len(f.Include) == 0 && f.IncludeRegex == nil {
+		return true
+	}
+	for _, glob := range f.Include {
+		if matchGlob(glob, rel) {
+			return true
+		}
+	}
+	return f.IncludeRegex != nil && f.IncludeRegex.MatchString(rel)
+}
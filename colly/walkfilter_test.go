@@ -0,0 +1,103 @@
+// Test Suit for walk filter
+package colly
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileWalker_Filter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]int{
+		"a.log":              10,
+		"a.txt":              10,
+		"old.log":            10,
+		"small.log":          1,
+		"app/b.log":          10,
+		"app/b.bak":          10,
+		"app/deep/c.log":     10,
+		"cache/d.log":        10,
+		"app/cache/e.log":    10,
+		"app/skip-me-f.log":  10,
+		"report/2017/g.csv":  10,
+		"report/2017/h.json": 10,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, make([]byte, size), 0644)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "old.log"), old, old)
+
+	filter, err := NewWalkFilter(&AppConfigOption{
+		WalkInclude:      "*.log",
+		WalkIncludeRegex: `^report/.*\.csv$`,
+		WalkExclude:      "app/deep/*",
+		WalkExcludeRegex: `skip-me`,
+		WalkExcludeDirs:  "cache",
+		WalkMaxDepth:     3,
+		WalkMinSize:      "5B",
+		WalkMaxAge:       60,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewDirectoryWorker(dir, 1, Rule{}, context.Background())
+	w.Filter = filter
+	items, errc := w.Walk()
+	var walked []string
+	for item := range items {
+		walked = append(walked, relPath(item.FileIndex))
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(walked)
+	if expect := "a.log,app/b.log,report/2017/g.csv"; strings.Join(walked, ",") != expect {
+		t.Errorf("expect %s, got %v", expect, walked)
+	}
+
+	if !w.IsSkipDir(filepath.Join(dir, "app", "cache")) || w.IsSkipDir(dir) || w.IsSkipDir(filepath.Join(dir, "app")) {
+		t.Error("only excluded directories should be pruned")
+	}
+	if _, ok := w.Item(filepath.Join(dir, "cache", "d.log")); ok {
+		t.Error("file in pruned directory should be filtered")
+	}
+	if _, ok := w.Item(filepath.Join(dir, "app", "b.log")); !ok {
+		t.Error("selected file should be accepted")
+	}
+
+	// depth 1 is collect directory only
+	w.Filter = &WalkFilter{MaxDepth: 1}
+	if !w.IsSkipDir(filepath.Join(dir, "app")) {
+		t.Error("directory below max depth should be pruned")
+	}
+
+	invalid := []*AppConfigOption{
+		{WalkInclude: "["},
+		{WalkExcludeDirs: "a/["},
+		{WalkExcludeRegex: "("},
+		{WalkMaxDepth: -1},
+	}
+	for _, opts := range invalid {
+		if _, err := NewWalkFilter(opts); err == nil {
+			t.Errorf("walk filter should be rejected: %+v", opts)
+		}
+	}
+	if f, err := NewWalkFilter(&AppConfigOption{}); f != nil || err != nil {
+		t.Error("no walk filter should be set by default")
+	}
+}
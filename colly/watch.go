@@ -0,0 +1,125 @@
+// Event driven collecting with periodic full walk
+package colly
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Watch collect files on directory events until shut down, a full walk
+// runs at start, on event overflow and every walk interval to catch the
+// files events missed
+func (c *Collector) Watch() error {
+
+	watcher, err := NewDirWatcher(c.UserConfigs.CollectDirectory, c.FileWalkerInst.IsSkipDir)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	fileItems := make(chan FileItem)
+	go c.watchFeed(watcher, fileItems)
+	c.run(fileItems)
+
+	return nil
+}
+
+// watchFeed merge watcher events and full walk into file items
+func (c *Collector) watchFeed(watcher *DirWatcher, fileItems chan<- FileItem) {
+	defer close(fileItems)
+
+	interval := time.Duration(c.UserConfigs.WatchWalkInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// file just closed may still be rejected by read wait time
+	settle := time.Duration(c.UserConfigs.ReadWaitTime+1) * time.Second
+	ready := make(chan string, 1024)
+
+	// purge walks sent directory, it runs aside so events are not held
+	var purges sync.WaitGroup
+	var purging int32
+	defer purges.Wait()
+
+	// overflow while walking needs another walk, events after the walk
+	// reached a directory may be lost
+	var rescan bool
+	var walkItems <-chan FileItem
+	var walkErrc <-chan error
+	walkItems, walkErrc = c.FileWalkerInst.Walk()
+
+	for {
+		var item FileItem
+		var ok bool
+
+		select {
+		case <-c.ctx.Done():
+			return
+
+		case path, open := <-watcher.Events:
+			if !open {
+				return
+			}
+			path = c.Stability.Target(path)
+			time.AfterFunc(settle, func() {
+				select {
+				case ready <- path:
+				case <-c.ctx.Done():
+				}
+			})
+			continue
+
+		case path := <-ready:
+			if item, ok = c.FileWalkerInst.Item(path); !ok {
+				continue
+			}
+
+		case <-watcher.Overflow:
+			if walkItems == nil {
+				logger.Println("watch events overflow, start full walk")
+				walkItems, walkErrc = c.FileWalkerInst.Walk()
+			} else {
+				logger.Println("watch events overflow, walk again after current one")
+				rescan = true
+			}
+			continue
+
+		case <-ticker.C:
+			if atomic.CompareAndSwapInt32(&purging, 0, 1) {
+				purges.Add(1)
+				go func() {
+					defer purges.Done()
+					c.purge()
+					atomic.StoreInt32(&purging, 0)
+				}()
+			}
+			if walkItems == nil {
+				walkItems, walkErrc = c.FileWalkerInst.Walk()
+			}
+			continue
+
+		case item, ok = <-walkItems:
+			if !ok {
+				if err := <-walkErrc; err != nil {
+					logger.Println(err.Error())
+				}
+				walkItems, walkErrc = nil, nil
+				if rescan {
+					rescan = false
+					walkItems, walkErrc = c.FileWalkerInst.Walk()
+				}
+				continue
+			}
+		}
+
+		select {
+		case fileItems <- item:
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
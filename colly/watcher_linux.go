@@ -0,0 +1,166 @@
+// Watch directory change with inotify
+package colly
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	watchDirMask  = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE
+	watchEventBuf = 4096 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)
+)
+
+// DirWatcher report files which are closed after writing or moved into
+// the watched directory tree
+type DirWatcher struct {
+	sync.Mutex
+
+	// path of file ready to collect
+	Events chan string
+	// notified when kernel event queue overflow and events lost
+	Overflow chan struct{}
+
+	fd      *os.File
+	watches map[int]string
+	skip    func(string) bool
+}
+
+// NewDirWatcher watch directory and all its sub directories except
+// the ones skip returns true
+func NewDirWatcher(directory string, skip func(string) bool) (*DirWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	w := &DirWatcher{
+		Events:   make(chan string, 1024),
+		Overflow: make(chan struct{}, 1),
+		fd:       os.NewFile(uintptr(fd), "inotify"),
+		watches:  make(map[int]string),
+		skip:     skip,
+	}
+
+	if err := w.addTree(directory, false); err != nil {
+		w.fd.Close()
+		return nil, err
+	}
+
+	go w.readEvents()
+
+	return w, nil
+}
+
+// Close stop watching, Events is closed after that
+func (w *DirWatcher) Close() error {
+	return w.fd.Close()
+}
+
+// addTree add watch to directory recursively, files already inside are
+// reported if report is true, they are created before the watch is ready
+func (w *DirWatcher) addTree(directory string, report bool) error {
+	return filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// directory removed before watching
+			if os.IsNotExist(err) && path != directory {
+				return nil
+			}
+			return err
+		}
+
+		if !info.IsDir() {
+			if report && info.Mode().IsRegular() {
+				w.notify(path)
+			}
+			return nil
+		}
+
+		if w.skip != nil && w.skip(path) {
+			return filepath.SkipDir
+		}
+
+		wd, err := syscall.InotifyAddWatch(int(w.fd.Fd()), path, watchDirMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.Lock()
+		w.watches[wd] = path
+		w.Unlock()
+
+		return nil
+	})
+}
+
+func (w *DirWatcher) notify(path string) {
+	select {
+	case w.Events <- path:
+	default:
+		// consumer too slow, let full walk catch it
+		w.overflow()
+	}
+}
+
+func (w *DirWatcher) overflow() {
+	select {
+	case w.Overflow <- struct{}{}:
+	default:
+	}
+}
+
+func (w *DirWatcher) readEvents() {
+	defer close(w.Events)
+
+	buf := make([]byte, watchEventBuf)
+	for {
+		n, err := w.fd.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			w.handle(int(event.Wd), event.Mask, name)
+		}
+	}
+}
+
+func (w *DirWatcher) handle(wd int, mask uint32, name string) {
+
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.overflow()
+		return
+	}
+
+	w.Lock()
+	dir, ok := w.watches[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.watches, wd)
+	}
+	w.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	if mask&syscall.IN_ISDIR != 0 {
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			if err := w.addTree(path, true); err != nil {
+				w.overflow()
+			}
+		}
+		return
+	}
+
+	if mask&(syscall.IN_CLOSE_WRITE|syscall.IN_MOVED_TO) != 0 {
+		w.notify(path)
+	}
+}
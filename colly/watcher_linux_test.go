@@ -0,0 +1,52 @@
+// Test Suit for inotify directory watcher
+package colly
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func expectEvent(t *testing.T, w *DirWatcher, path string) {
+	select {
+	case got := <-w.Events:
+		if got != path {
+			t.Errorf("expect event of %s, got %s", path, got)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no event of %s", path)
+	}
+}
+
+func TestDirWatcher_Events(t *testing.T) {
+	dir, err := ioutil.TempDir("", "colly")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	skipped := filepath.Join(dir, DefaultSentDirectory)
+	os.MkdirAll(skipped, 0755)
+
+	w, err := NewDirWatcher(dir, func(path string) bool { return path == skipped })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	ioutil.WriteFile(filepath.Join(skipped, "a.txt"), []byte("a"), 0644)
+
+	file := filepath.Join(dir, "b.txt")
+	ioutil.WriteFile(file, []byte("b"), 0644)
+	expectEvent(t, w, file)
+
+	// new sub directory is watched too
+	sub := filepath.Join(dir, "sub")
+	os.Mkdir(sub, 0755)
+	time.Sleep(100 * time.Millisecond)
+	file = filepath.Join(sub, "c.txt")
+	ioutil.WriteFile(file, []byte("c"), 0644)
+	expectEvent(t, w, file)
+}
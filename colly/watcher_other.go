@@ -0,0 +1,22 @@
+//go:build !linux
+// +build !linux
+
+package colly
+
+import "errors"
+
+// DirWatcher is only supported on linux
+type DirWatcher struct {
+	Events   chan string
+	Overflow chan struct{}
+}
+
+// NewDirWatcher is only supported on linux
+func NewDirWatcher(directory string, skip func(string) bool) (*DirWatcher, error) {
+	return nil, errors.New("watch mode is only supported on linux")
+}
+
+// Close stop watching
+func (w *DirWatcher) Close() error {
+	return nil
+}
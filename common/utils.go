@@ -7,6 +7,10 @@ import "strconv"
 // machine bytes
 func HumanSize2Bytes(size string) int64 {
 
+	if size == "" {
+		return 0
+	}
+
 	weight := size[len(size)-1]
 	ret, err := strconv.Atoi(size[:len(size)-1])
 	if err != nil {
@@ -4,7 +4,8 @@ var helpTemplate = `NAME:
    {{.Name}} - {{.Usage}}
 
 USAGE:
-   {{.Name}} [options]
+   {{.Name}} [options]{{if .Commands}}
+   {{.Name}} command [options]{{end}}
 
 VERSION:
    {{.Version}}{{if or .Author .Email}}
@@ -13,7 +14,10 @@ AUTHOR:{{if .Author}}
   {{.Author}}{{if .Email}} - <{{.Email}}>{{end}}{{else}}
   {{.Email}}{{end}}{{end}}
 
-OPTIONS:
+{{if .Commands}}COMMANDS:
+   {{range .Commands}}{{join .Names ", "}}{{ "\t" }}{{.Usage}}
+   {{end}}
+{{end}}OPTIONS:
    {{range .Flags}}{{.}}
    {{end}}
 `
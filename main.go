@@ -4,8 +4,10 @@ import (
 	"os"
 	"os/signal"
 	"fmt"
+	"log"
 	"syscall"
 
+	"github.com/go-redis/redis"
 	"github.com/urfave/cli"
 	"github.com/yudai/gotty/pkg/homedir"
 	"github.com/smileboywtu/FileColly/common"
@@ -34,30 +36,49 @@ func main() {
 		exit(err, 1)
 	}
 
+	receiveOptions := &collector.ReceiveConfigOption{}
+	if err := common.ApplyDefaultValues(receiveOptions); err != nil {
+		exit(err, 1)
+	}
+
 	cliFlags, flagMappings, err := common.GenerateFlags(appOptions)
 	if err != nil {
 		exit(err, 3)
 	}
 
-	app.Flags = append(
-		cliFlags,
-		cli.StringFlag{
-			Name:   "config",
-			Value:  "config.yaml",
-			Usage:  "Config file path",
-			EnvVar: "COLLY_CONFIG",
+	receiveFlags, receiveMappings, err := common.GenerateFlags(appOptions, receiveOptions)
+	if err != nil {
+		exit(err, 3)
+	}
+
+	configFlag := cli.StringFlag{
+		Name:   "config",
+		Value:  "config.yaml",
+		Usage:  "Config file path",
+		EnvVar: "COLLY_CONFIG",
+	}
+
+	app.Flags = append(cliFlags, configFlag)
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "receive",
+			Usage: "pop files from redis and restore them under output directory",
+			Flags: append(receiveFlags, configFlag),
+			Action: func(c *cli.Context) {
+				loadConfigFile(c, appOptions, receiveOptions)
+				common.ApplyFlags(receiveFlags, receiveMappings, c, appOptions, receiveOptions)
+
+				if err := receive(appOptions, receiveOptions); err != nil {
+					exit(err, 5)
+				}
+			},
 		},
-	)
+	}
 
 	app.Action = func(c *cli.Context) {
 
-		configFile := c.String("config")
-		_, err := os.Stat(homedir.Expand(configFile))
-		if configFile != "config.yaml" || !os.IsNotExist(err) {
-			if err := common.ApplyConfigFileYaml(configFile, appOptions); err != nil {
-				exit(err, 2)
-			}
-		}
+		loadConfigFile(c, appOptions)
 
 		common.ApplyFlags(cliFlags, flagMappings, c, appOptions)
 
@@ -66,6 +87,10 @@ func main() {
 			fmt.Fprintf(os.Stderr, "start error: %s", errs.Error())
 			os.Exit(-1)
 		}
+		defer colly.Close()
+
+		// sentinel failover and cluster redirect events of redis client
+		redis.SetLogger(log.New(collector.LogOutput(), "redis: ", log.LstdFlags))
 
 		sigs := make(chan os.Signal, 1)
 		signal.Notify(sigs, syscall.SIGKILL, syscall.SIGTERM)
@@ -78,15 +103,37 @@ func main() {
 		colly.FileWalkerInst.OnFilter(collector.FileWalkerGenericFilter)
 		colly.OnFilter(collector.CollectorGenericFilter)
 
+		if appOptions.WatchMode {
+			if err := colly.Watch(); err != nil {
+				exit(err, 4)
+			}
+			return
+		}
+
 		for {
 			colly.Start()
-			time.Sleep(time.Duration(1 * time.Second))
+			select {
+			case <-colly.Done():
+				return
+			case <-time.After(time.Duration(1 * time.Second)):
+			}
 		}
 	}
 
 	app.Run(os.Args)
 }
 
+// loadConfigFile apply config file, the default one is optional
+func loadConfigFile(c *cli.Context, options ...interface{}) {
+	configFile := c.String("config")
+	_, err := os.Stat(homedir.Expand(configFile))
+	if configFile != "config.yaml" || !os.IsNotExist(err) {
+		if err := common.ApplyConfigFileYaml(configFile, options...); err != nil {
+			exit(err, 2)
+		}
+	}
+}
+
 func exit(err error, code int) {
 	if err != nil {
 		fmt.Println(err)
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+
+	collector "github.com/smileboywtu/FileColly/colly"
+	"github.com/smileboywtu/FileColly/colly/decode"
+)
+
+// receive pop messages from destination queue and restore files under
+// output directory until terminated
+func receive(appOptions *collector.AppConfigOption, opts *collector.ReceiveConfigOption) error {
+
+	if opts.ReceivePolicy != decode.PolicyOverwrite && opts.ReceivePolicy != decode.PolicySkip {
+		return fmt.Errorf("unknown receive policy: %s", opts.ReceivePolicy)
+	}
+	if err := os.MkdirAll(opts.OutputDirectory, 0755); err != nil {
+		return err
+	}
+
+	if opts.ReceiveConsumer == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return err
+		}
+		opts.ReceiveConsumer = host
+	}
+
+	var queues []decode.Queue
+	var store *collector.RedisClaimStore
+	switch appOptions.Backend {
+	case collector.BackendSpool:
+		if appOptions.SpoolDirectory == "" {
+			return fmt.Errorf("spool directory is not set")
+		}
+		queue := &decode.SpoolQueue{Directory: appOptions.SpoolDirectory}
+		n, err := queue.Recover()
+		if err != nil {
+			return fmt.Errorf("recover messages of %s error: %s", appOptions.SpoolDirectory, err)
+		}
+		if n > 0 {
+			log.Printf("requeue %d messages left in %s", n, appOptions.SpoolDirectory)
+		}
+		queues = append(queues, queue)
+	case collector.BackendHTTP:
+		return fmt.Errorf("http backend has no queue to receive from")
+	default:
+		client, err := appOptions.RedisClient()
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if err := client.Ping().Err(); err != nil {
+			return fmt.Errorf("redis connect error: %s", err)
+		}
+		claimCheck := appOptions.RedisClaimCheck && appOptions.Backend != collector.BackendS3 && appOptions.Backend != collector.BackendStream
+		if claimCheck {
+			store = &collector.RedisClaimStore{Client: client}
+		}
+
+		for _, name := range queueNames(appOptions) {
+			var queue decode.Queue
+			if appOptions.Backend == collector.BackendStream {
+				stream, err := decode.NewStreamQueue(client, name, opts.ReceiveGroup, opts.ReceiveConsumer)
+				if err != nil {
+					return fmt.Errorf("create consumer group of %s error: %s", name, err)
+				}
+				queue = stream
+			} else {
+				queue = &decode.RedisQueue{Client: client, Name: name, Consumer: opts.ReceiveConsumer}
+			}
+
+			// messages left by last run of this consumer are restored first
+			n, err := queue.(decode.Recoverer).Recover()
+			if err != nil {
+				return fmt.Errorf("recover messages of %s error: %s", name, err)
+			}
+			if n > 0 {
+				log.Printf("requeue %d messages left in %s", n, name)
+			}
+
+			if claimCheck {
+				queue = &decode.ClaimQueue{Queue: queue, Fetcher: store}
+			}
+			queues = append(queues, queue)
+		}
+	}
+	if appOptions.Backend == collector.BackendS3 {
+		if !appOptions.S3ClaimCheck {
+			return fmt.Errorf("receive from s3 backend needs claim check mode")
+		}
+		s3, err := collector.NewS3Client(appOptions)
+		if err != nil {
+			return err
+		}
+		for i := range queues {
+			queues[i] = &decode.ClaimQueue{Queue: queues[i], Fetcher: s3}
+		}
+	}
+	restorer := decode.NewRestorer(opts.OutputDirectory)
+	restorer.Policy = opts.ReceivePolicy
+	restorer.Timeout = time.Duration(opts.ChunkTimeout) * time.Second
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n := restorer.Expire(); n > 0 {
+					log.Printf("drop incomplete files: %d", n)
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(opts.ReceiveWorkers * len(queues))
+	for i := 0; i < opts.ReceiveWorkers*len(queues); i++ {
+		queue := queues[i%len(queues)]
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				target, done, err := decode.Receive(queue, restorer, time.Second)
+				switch {
+				case err == decode.ErrEmpty:
+				case err == decode.ErrExists:
+					log.Println("skip existing file: ", target)
+				case errors.Cause(err) == collector.ErrClaimExpired:
+					log.Printf("file lost, %s, expired so far: %d", err, atomic.LoadInt64(&store.Expired))
+				case errors.Cause(err) == collector.ErrClaimMissing:
+					log.Println("file lost: ", err)
+				case err != nil:
+					log.Println("receive error: ", err)
+					// redis may be down, do not spin
+					time.Sleep(time.Second)
+				case done:
+					log.Println("receive file: ", target)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// queueNames list destination queue and queues of priority classes, the
+// workers read every queue collector may push to
+func queueNames(appOptions *collector.AppConfigOption) []string {
+	names := []string{appOptions.DestinationRedisQueueName}
+	seen := map[string]bool{appOptions.DestinationRedisQueueName: true}
+	for _, class := range appOptions.Priorities {
+		if class.Queue != "" && !seen[class.Queue] {
+			seen[class.Queue] = true
+			names = append(names, class.Queue)
+		}
+	}
+	return names
+}